@@ -0,0 +1,811 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+/*
+PrefixTrie, an array mapped trie of alias prefixes of arbitrary length,
+which implements radix.Store.
+*/
+
+package amt
+
+import (
+	"aliasv6/radix"
+	"io"
+	"log"
+	"math"
+	"math/bits"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// prefixNode is a node of the alias prefix trie. Like Node, it has a child
+// per nibble set in its bitmap. An alias prefix is kept in the node of its
+// complete nibbles rather than expanded to the next nibble boundary, e.g. a
+// /50 is kept in the node of its first 48 bits along with the two bits
+// which follow.
+type prefixNode struct {
+	bitmap   uint16
+	children []*prefixNode
+	// aliases are the alias prefixes kept in the node, in address order.
+	aliases *alias
+	// gen is the generation of the modification which created the node.
+	gen uint64
+}
+
+// alias is an alias prefix kept in a node. Alias prefixes never overlap.
+type alias struct {
+	// bits is the length of the alias prefix.
+	bits uint8
+	// nibble is the next nibble of the alias prefix after the node, in
+	// which only the bits within the prefix length may be set.
+	nibble byte
+	info   *radix.LeafInfo
+	next   *alias
+}
+
+// PrefixTrie is a copy-on-write array mapped trie of alias prefixes, with
+// 4-bit strides like the AMT of addresses. Like the radix tree, a
+// modification copies the nodes on its path and publishes the new root at
+// once, so lookups need no locks and always see a consistent trie.
+type PrefixTrie struct {
+	root atomic.Pointer[prefixNode]
+	// writer serializes the modifications of the trie.
+	writer sync.Mutex
+	// gen is the generation of the nodes which can be modified in place by
+	// the current modification. Older nodes are copied before modification.
+	gen                       uint64
+	isChanged                 atomic.Bool
+	constructionNewAliasFound atomic.Bool
+	policy                    radix.AggregationPolicy
+	checkpoints               radix.CheckpointSettings
+	checkpointFrequency       float32
+}
+
+// InitPrefixTrie returns an empty trie with the default settings of the
+// radix tree.
+func InitPrefixTrie() *PrefixTrie {
+	t := &PrefixTrie{
+		policy: &radix.SiblingPolicy{},
+		checkpoints: radix.CheckpointSettings{
+			BaseName: "checkpoint",
+			Format:   radix.FormatText,
+		},
+		checkpointFrequency: 1.0,
+	}
+	t.root.Store(&prefixNode{})
+	return t
+}
+
+// generation hands out a distinct generation to every modification of any
+// trie, so nodes shared between tries are never mistaken for new ones.
+var generation atomic.Uint64
+
+// nibbleAt returns the i-th nibble of raw, in the order of getNibble.
+func nibbleAt(raw *[16]byte, i int) byte {
+	return getNibble(raw[i/2], uint8(4-(4*(i%2))))
+}
+
+// setNibble sets the i-th nibble of raw to value.
+func setNibble(raw *[16]byte, i int, value byte) {
+	if i%2 == 0 {
+		raw[i/2] = raw[i/2]&0x0F | value<<4
+	} else {
+		raw[i/2] = raw[i/2]&0xF0 | value
+	}
+}
+
+// nibbleMask returns the mask of the first ones bits of a nibble.
+func nibbleMask(ones int) byte {
+	return byte(0xF<<(4-ones)) & 0x0F
+}
+
+// normalizePrefix maps IPv4 prefixes into ::ffff:0:0/96 and masks the
+// prefix, like the radix tree does.
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	if prefix.Addr().Is4() {
+		prefix = netip.PrefixFrom(netip.AddrFrom16(prefix.Addr().As16()), prefix.Bits()+96)
+	}
+	return prefix.Masked()
+}
+
+// familyPrefix returns the prefix of the given length of raw, as an IPv4
+// prefix if it is inside ::ffff:0:0/96.
+func familyPrefix(raw [16]byte, ones int) netip.Prefix {
+	prefix := netip.PrefixFrom(netip.AddrFrom16(raw), ones).Masked()
+	if ones >= 96 && prefix.Addr().Is4In6() {
+		return netip.PrefixFrom(prefix.Addr().Unmap(), ones-96)
+	}
+	return prefix
+}
+
+func (n *prefixNode) getIndex(nibble byte) int {
+	bitPosition := 1 << nibble
+	if (n.bitmap & uint16(bitPosition)) == 0 {
+		return -1
+	}
+	return int(bits.OnesCount16(n.bitmap & (uint16(bitPosition - 1))))
+}
+
+// contains reports whether the alias prefix of a node at the given depth
+// contains the addresses whose next nibble is nibble.
+func (a *alias) contains(depth int, nibble byte) bool {
+	return nibble&nibbleMask(int(a.bits)-4*depth) == a.nibble
+}
+
+// coveringAlias returns the alias prefix containing the first ones bits of
+// raw, or nil. ones is 128 for the lookup of an address.
+func coveringAlias(root *prefixNode, raw *[16]byte, ones int) *alias {
+	n := root
+	for depth := 0; ; depth++ {
+		var nibble byte
+		if depth < 32 {
+			nibble = nibbleAt(raw, depth)
+		}
+		for a := n.aliases; a != nil; a = a.next {
+			if int(a.bits) <= ones && a.contains(depth, nibble) {
+				return a
+			}
+		}
+		// Deeper alias prefixes are longer than ones.
+		if depth == 32 || 4*(depth+1) > ones {
+			return nil
+		}
+		index := n.getIndex(nibble)
+		if index == -1 {
+			return nil
+		}
+		n = n.children[index]
+	}
+}
+
+// findNode returns the node at the given depth on the path of raw, or nil.
+func findNode(root *prefixNode, raw *[16]byte, depth int) *prefixNode {
+	n := root
+	for i := 0; i < depth; i++ {
+		index := n.getIndex(nibbleAt(raw, i))
+		if index == -1 {
+			return nil
+		}
+		n = n.children[index]
+	}
+	return n
+}
+
+// countAliases returns the number of alias prefixes in the sub-trie of n.
+func (n *prefixNode) countAliases() int {
+	count := 0
+	for a := n.aliases; a != nil; a = a.next {
+		count++
+	}
+	for _, child := range n.children {
+		count += child.countAliases()
+	}
+	return count
+}
+
+// walk calls fn for the alias prefixes in the sub-trie of n, a node at the
+// given depth on the path of raw, in address order until fn returns false.
+// In n itself, only the alias prefixes and the children whose next nibble
+// matches nibble under mask, and the alias prefixes of at least ones bits,
+// are visited. It reports whether every alias prefix was visited.
+func (n *prefixNode) walk(raw [16]byte, depth int, mask, nibble byte, ones int, fn func(prefix netip.Prefix, info *radix.LeafInfo) bool) bool {
+	a := n.aliases
+	for value := byte(0); value < 16; value++ {
+		if depth < 32 {
+			setNibble(&raw, depth, value)
+		}
+		if a != nil && a.nibble == value {
+			if value&mask == nibble && int(a.bits) >= ones {
+				if !fn(netip.PrefixFrom(netip.AddrFrom16(raw), int(a.bits)), a.info) {
+					return false
+				}
+			}
+			a = a.next
+		}
+		if depth == 32 || value&mask != nibble {
+			continue
+		}
+		if index := n.getIndex(value); index != -1 {
+			if !n.children[index].walk(raw, depth+1, 0, 0, 0, fn) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// walkAliases calls fn for each alias prefix inside the normalized prefix
+// within, or in the whole trie if within is the zero Prefix, in address
+// order until fn returns false. The attributes passed to fn are only read.
+func walkAliases(root *prefixNode, within netip.Prefix, fn func(prefix netip.Prefix, info *radix.LeafInfo) bool) {
+	var raw [16]byte
+	ones := 0
+	if within.IsValid() {
+		raw, ones = within.Addr().As16(), within.Bits()
+	}
+	depth := ones / 4
+	n := findNode(root, &raw, depth)
+	if n == nil {
+		return
+	}
+	var mask, nibble byte
+	if depth < 32 {
+		mask = nibbleMask(ones % 4)
+		nibble = nibbleAt(&raw, depth) & mask
+	}
+	n.walk(raw, depth, mask, nibble, ones, fn)
+}
+
+// copyInfo returns a copy of the attributes of an alias prefix.
+func copyInfo(info *radix.LeafInfo) *radix.LeafInfo {
+	if info == nil {
+		return nil
+	}
+	dup := *info
+	return &dup
+}
+
+// copyAliases returns a copy of the alias prefixes of a node.
+func copyAliases(a *alias) *alias {
+	if a == nil {
+		return nil
+	}
+	dup := *a
+	dup.info = copyInfo(a.info)
+	dup.next = copyAliases(a.next)
+	return &dup
+}
+
+// rootNode returns the published root of the trie.
+func (t *PrefixTrie) rootNode() *prefixNode {
+	return t.root.Load()
+}
+
+// mutable returns n if it was created by the current modification, or a
+// copy of n which can be modified otherwise.
+func (t *PrefixTrie) mutable(n *prefixNode) *prefixNode {
+	if n.gen == t.gen {
+		return n
+	}
+	dup := *n
+	dup.gen = t.gen
+	dup.children = append([]*prefixNode(nil), n.children...)
+	dup.aliases = copyAliases(n.aliases)
+	return &dup
+}
+
+// mutablePath makes the nodes on the path of raw down to the given depth
+// modifiable, creating the missing ones, and returns them from the root.
+func (t *PrefixTrie) mutablePath(raw *[16]byte, depth int) []*prefixNode {
+	n := t.mutable(t.rootNode())
+	t.root.Store(n)
+	path := []*prefixNode{n}
+	for i := 0; i < depth; i++ {
+		nibble := nibbleAt(raw, i)
+		index := n.getIndex(nibble)
+		if index == -1 {
+			n.bitmap |= 1 << nibble
+			index = n.getIndex(nibble)
+			n.children = append(n.children, nil)
+			copy(n.children[index+1:], n.children[index:])
+			n.children[index] = &prefixNode{gen: t.gen}
+		}
+		child := t.mutable(n.children[index])
+		n.children[index] = child
+		n = child
+		path = append(path, n)
+	}
+	return path
+}
+
+// removeChild removes the child of the nibble from n.
+func (n *prefixNode) removeChild(nibble byte) {
+	index := n.getIndex(nibble)
+	n.children = append(n.children[:index], n.children[index+1:]...)
+	n.bitmap &^= 1 << nibble
+	if len(n.children) == 0 {
+		n.children = nil
+	}
+}
+
+// removeInside removes the alias prefixes of n whose next nibble matches
+// nibble under mask and which are at least ones bits long, along with the
+// children under them if subtree is set. It returns the number of removed
+// alias prefixes.
+func (n *prefixNode) removeInside(mask, nibble byte, ones int, subtree bool) int {
+	removed := 0
+	for link := &n.aliases; *link != nil; {
+		if a := *link; a.nibble&mask == nibble && int(a.bits) >= ones {
+			*link = a.next
+			removed++
+		} else {
+			link = &a.next
+		}
+	}
+	if !subtree {
+		return removed
+	}
+	for value := byte(0); value < 16; value++ {
+		if value&mask != nibble {
+			continue
+		}
+		if index := n.getIndex(value); index != -1 {
+			removed += n.children[index].countAliases()
+			n.removeChild(value)
+		}
+	}
+	return removed
+}
+
+// addAlias adds an alias prefix to n in address order.
+func (n *prefixNode) addAlias(a *alias) {
+	link := &n.aliases
+	for *link != nil && (*link).nibble < a.nibble {
+		link = &(*link).next
+	}
+	a.next = *link
+	*link = a
+}
+
+// prune removes the nodes without alias prefixes or children from the end
+// of the path of raw.
+func (t *PrefixTrie) prune(path []*prefixNode, raw *[16]byte) {
+	for depth := len(path) - 1; depth > 0; depth-- {
+		if n := path[depth]; n.aliases != nil || len(n.children) > 0 {
+			return
+		}
+		path[depth-1].removeChild(nibbleAt(raw, depth-1))
+	}
+}
+
+// insertAlias adds the normalized prefix as an alias prefix with the given
+// attributes without looking for new aliases. Alias prefixes under it are
+// pruned. It returns false if the prefix was already covered by an alias
+// prefix, in which case only the attributes of an identical alias prefix
+// are updated.
+func (t *PrefixTrie) insertAlias(prefix netip.Prefix, info *radix.LeafInfo) bool {
+	raw, ones := prefix.Addr().As16(), prefix.Bits()
+	depth := ones / 4
+	var mask, nibble byte
+	if depth < 32 {
+		mask = nibbleMask(ones % 4)
+		nibble = nibbleAt(&raw, depth) & mask
+	}
+	if covering := coveringAlias(t.rootNode(), &raw, ones); covering != nil {
+		if int(covering.bits) < ones {
+			return false
+		}
+		// Identical alias prefix, it is confirmed once more.
+		path := t.mutablePath(&raw, depth)
+		a := path[depth].aliases
+		for a.nibble != nibble {
+			a = a.next
+		}
+		if a.info == nil {
+			a.info = info
+			t.isChanged.Store(true)
+		} else if a.info.Confirm(info.LastConfirmed, info.Tags) {
+			t.isChanged.Store(true)
+		}
+		return false
+	}
+	path := t.mutablePath(&raw, depth)
+	n := path[depth]
+	if n.removeInside(mask, nibble, ones, true) > 0 {
+		t.constructionNewAliasFound.Store(true)
+	}
+	n.addAlias(&alias{bits: uint8(ones), nibble: nibble, info: info})
+	t.isChanged.Store(true)
+	return true
+}
+
+// aggregate asks the aggregation policy whether the new alias prefix
+// allows inferring an aliased parent prefix, like the radix tree does. It
+// returns the largest inferred parent, which is invalid if there is none.
+func (t *PrefixTrie) aggregate(prefix netip.Prefix, seen time.Time) netip.Prefix {
+	var largest netip.Prefix
+	for {
+		parent := t.policy.Aggregate(t, prefix)
+		if !parent.IsValid() {
+			return largest
+		}
+		parent = normalizePrefix(parent)
+		if parent.Bits() >= prefix.Bits() || !parent.Contains(prefix.Addr()) {
+			log.Fatalf("aggregation policy returned %s which does not cover %s", parent, prefix)
+		}
+		info := radix.NewLeafInfo(radix.SourceMerge, seen, nil)
+		info.MergedFrom = []string{}
+		walkAliases(t.rootNode(), parent, func(prefix netip.Prefix, _ *radix.LeafInfo) bool {
+			info.MergedFrom = append(info.MergedFrom, familyPrefix(prefix.Addr().As16(), prefix.Bits()).String())
+			return true
+		})
+		if !t.insertAlias(parent, info) {
+			return largest
+		}
+		t.constructionNewAliasFound.Store(true)
+		prefix = parent
+		largest = parent
+	}
+}
+
+// insertPrefix adds prefix as an alias prefix, looks for new aliases and
+// returns the effect of the insertion.
+func (t *PrefixTrie) insertPrefix(prefix netip.Prefix, info *radix.LeafInfo) radix.InsertResult {
+	prefix = normalizePrefix(prefix)
+	raw, ones := prefix.Addr().As16(), prefix.Bits()
+	if covering := coveringAlias(t.rootNode(), &raw, ones); covering != nil {
+		effect := radix.InsertCovered
+		if int(covering.bits) == ones {
+			// confirms the identical alias prefix
+			t.insertAlias(prefix, info)
+			effect = radix.InsertConfirmed
+		}
+		return radix.InsertResult{Effect: effect, Prefix: familyPrefix(raw, int(covering.bits))}
+	}
+	result := radix.InsertResult{Effect: radix.InsertNew, Prefix: familyPrefix(raw, ones)}
+	walkAliases(t.rootNode(), prefix, func(netip.Prefix, *radix.LeafInfo) bool {
+		result.Pruned++
+		return true
+	})
+	if t.insertAlias(prefix, info) {
+		if parent := t.aggregate(prefix, info.LastConfirmed); parent.IsValid() {
+			result.Effect = radix.InsertMerged
+			result.Prefix = familyPrefix(parent.Addr().As16(), parent.Bits())
+		}
+	}
+	return result
+}
+
+// deletePrefix removes the alias prefix. Only an exact match is removed
+// unless subtree is set, in which case every alias prefix under it is
+// removed as well. It returns the number of removed alias prefixes.
+func (t *PrefixTrie) deletePrefix(prefix netip.Prefix, subtree bool) int {
+	prefix = normalizePrefix(prefix)
+	raw, ones := prefix.Addr().As16(), prefix.Bits()
+	depth := ones / 4
+	var mask, nibble byte
+	if depth < 32 {
+		mask = nibbleMask(ones % 4)
+		nibble = nibbleAt(&raw, depth) & mask
+	}
+	n := findNode(t.rootNode(), &raw, depth)
+	if n == nil {
+		return 0
+	}
+	found := false
+	if subtree {
+		n.walk(raw, depth, mask, nibble, ones, func(netip.Prefix, *radix.LeafInfo) bool {
+			found = true
+			return false
+		})
+	} else {
+		for a := n.aliases; a != nil; a = a.next {
+			found = found || (int(a.bits) == ones && a.nibble == nibble)
+		}
+	}
+	if !found {
+		return 0
+	}
+	path := t.mutablePath(&raw, depth)
+	removed := 0
+	if subtree {
+		removed = path[depth].removeInside(mask, nibble, ones, true)
+	} else {
+		// Alias prefixes never overlap, so the prefix itself is the only
+		// one with the same next nibble which is at least as long.
+		removed = path[depth].removeInside(0x0F, nibble, ones, false)
+	}
+	t.prune(path, &raw)
+	t.isChanged.Store(true)
+	return removed
+}
+
+// update applies modify to a draft of the trie, which shares the nodes of
+// the trie until they are modified, and then publishes the root of the
+// draft. Readers see the trie either before or after the modification.
+func (t *PrefixTrie) update(modify func(draft *PrefixTrie)) {
+	t.writer.Lock()
+	defer t.writer.Unlock()
+	draft := &PrefixTrie{policy: t.policy, gen: generation.Add(1)}
+	draft.root.Store(t.rootNode())
+	modify(draft)
+	t.root.Store(draft.rootNode())
+	if draft.isChanged.Load() {
+		t.isChanged.Store(true)
+	}
+	if draft.constructionNewAliasFound.Load() {
+		t.constructionNewAliasFound.Store(true)
+	}
+}
+
+// aliasLabel returns the label of an alias prefix with a copy of its attributes.
+func aliasLabel(prefix netip.Prefix, info *radix.LeafInfo) radix.Label {
+	prefix = normalizePrefix(prefix)
+	return radix.Label{
+		Aliased:  true,
+		Metadata: familyPrefix(prefix.Addr().As16(), prefix.Bits()).String(),
+		Info:     copyInfo(info),
+	}
+}
+
+// lookUpMatch returns the alias prefix containing addr in the trie of root.
+func lookUpMatch(root *prefixNode, addr netip.Addr) (radix.Match, bool) {
+	raw := addr.As16()
+	a := coveringAlias(root, &raw, 128)
+	if a == nil {
+		return radix.Match{}, false
+	}
+	return radix.Match{Prefix: familyPrefix(raw, int(a.bits)), Info: a.info}, true
+}
+
+// Insert adds prefix as an alias prefix received with an insert command.
+func (t *PrefixTrie) Insert(prefix netip.Prefix) {
+	t.InsertWithInfo(prefix, radix.NewLeafInfo(radix.SourceInsert, time.Now(), nil))
+}
+
+// InsertWithInfo adds prefix as an alias prefix with the given attributes
+// and returns the effect of the insertion.
+// If prefix is already an alias prefix, its last confirmation time and tags
+// are updated instead.
+func (t *PrefixTrie) InsertWithInfo(prefix netip.Prefix, info *radix.LeafInfo) radix.InsertResult {
+	var result radix.InsertResult
+	t.update(func(draft *PrefixTrie) {
+		result = draft.insertPrefix(prefix, info)
+	})
+	return result
+}
+
+// Delete removes the exact alias prefix and reports whether it was present.
+func (t *PrefixTrie) Delete(prefix netip.Prefix) bool {
+	removed := 0
+	t.update(func(draft *PrefixTrie) {
+		removed = draft.deletePrefix(prefix, false)
+	})
+	return removed == 1
+}
+
+// DeleteSubtree removes prefix and every alias prefix under it. It returns
+// the number of removed alias prefixes.
+func (t *PrefixTrie) DeleteSubtree(prefix netip.Prefix) int {
+	removed := 0
+	t.update(func(draft *PrefixTrie) {
+		removed = draft.deletePrefix(prefix, true)
+	})
+	return removed
+}
+
+// IsAliased reports whether the whole prefix is inside an alias prefix.
+func (t *PrefixTrie) IsAliased(prefix netip.Prefix) bool {
+	prefix = normalizePrefix(prefix)
+	raw := prefix.Addr().As16()
+	return coveringAlias(t.rootNode(), &raw, prefix.Bits()) != nil
+}
+
+// LookUp returns the label of the alias prefix containing addr.
+func (t *PrefixTrie) LookUp(addr netip.Addr) radix.Label {
+	match, found := lookUpMatch(t.rootNode(), addr)
+	if !found {
+		return radix.Label{}
+	}
+	return aliasLabel(match.Prefix, match.Info)
+}
+
+// LookUpMatch returns the alias prefix containing addr and whether there is
+// one. It does not allocate.
+func (t *PrefixTrie) LookUpMatch(addr netip.Addr) (radix.Match, bool) {
+	return lookUpMatch(t.rootNode(), addr)
+}
+
+// LookUpBatch returns the labels of the alias prefixes containing each of
+// addrs, in the same order. The addresses are looked up on the same version
+// of the trie.
+func (t *PrefixTrie) LookUpBatch(addrs []netip.Addr) []radix.Label {
+	root := t.rootNode()
+	labels := make([]radix.Label, len(addrs))
+	for i, addr := range addrs {
+		if match, found := lookUpMatch(root, addr); found {
+			labels[i] = aliasLabel(match.Prefix, match.Info)
+		}
+	}
+	return labels
+}
+
+// LookUpPrefix reports whether prefix is fully, partially or not covered by
+// the alias prefixes in the trie.
+func (t *PrefixTrie) LookUpPrefix(prefix netip.Prefix) radix.Coverage {
+	root := t.rootNode()
+	prefix = normalizePrefix(prefix)
+	raw, ones := prefix.Addr().As16(), prefix.Bits()
+	if a := coveringAlias(root, &raw, ones); a != nil {
+		return radix.Coverage{
+			Coverage: radix.CoverageFull,
+			Prefixes: []string{familyPrefix(raw, int(a.bits)).String()},
+			Fraction: 1,
+			Info:     copyInfo(a.info),
+		}
+	}
+	coverage := radix.Coverage{Coverage: radix.CoveragePartial, Prefixes: []string{}}
+	walkAliases(root, prefix, func(inside netip.Prefix, _ *radix.LeafInfo) bool {
+		coverage.Prefixes = append(coverage.Prefixes, familyPrefix(inside.Addr().As16(), inside.Bits()).String())
+		coverage.Fraction += math.Ldexp(1, ones-inside.Bits())
+		return true
+	})
+	if len(coverage.Prefixes) == 0 {
+		return radix.Coverage{Coverage: radix.CoverageNone}
+	}
+	return coverage
+}
+
+// Covering returns the alias prefixes which contain prefix, including prefix
+// itself if it is an alias prefix. Alias prefixes never overlap, so there is
+// at most one.
+func (t *PrefixTrie) Covering(prefix netip.Prefix) []radix.Label {
+	labels := []radix.Label{}
+	prefix = normalizePrefix(prefix)
+	raw := prefix.Addr().As16()
+	if a := coveringAlias(t.rootNode(), &raw, prefix.Bits()); a != nil {
+		labels = append(labels, aliasLabel(netip.PrefixFrom(prefix.Addr(), int(a.bits)), a.info))
+	}
+	return labels
+}
+
+// CoveredBy returns the alias prefixes inside prefix, including prefix itself
+// if it is an alias prefix, in address order.
+func (t *PrefixTrie) CoveredBy(prefix netip.Prefix) []radix.Label {
+	labels := []radix.Label{}
+	walkAliases(t.rootNode(), normalizePrefix(prefix), func(inside netip.Prefix, info *radix.LeafInfo) bool {
+		labels = append(labels, aliasLabel(inside, info))
+		return true
+	})
+	return labels
+}
+
+// Walk calls fn for each alias prefix in the trie in address order until fn
+// returns false. It walks the trie as of the call.
+func (t *PrefixTrie) Walk(fn func(prefix netip.Prefix, info radix.LeafInfo) bool) {
+	walkAliases(t.rootNode(), netip.Prefix{}, func(prefix netip.Prefix, info *radix.LeafInfo) bool {
+		var copied radix.LeafInfo
+		if info != nil {
+			copied = *info
+		}
+		return fn(familyPrefix(prefix.Addr().As16(), prefix.Bits()), copied)
+	})
+}
+
+// Count returns the number of alias prefixes in the trie.
+func (t *PrefixTrie) Count() int {
+	return t.rootNode().countAliases()
+}
+
+// walkPrefixes calls fn for each alias prefix and its attributes in address
+// order until fn returns false.
+func (t *PrefixTrie) walkPrefixes(fn func(prefix netip.Prefix, info *radix.LeafInfo) bool) {
+	walkAliases(t.rootNode(), netip.Prefix{}, fn)
+}
+
+// Export writes the alias prefixes to w in the given checkpoint format.
+// createdAt is recorded in the header of binary checkpoints.
+func (t *PrefixTrie) Export(w io.Writer, format string, createdAt time.Time) error {
+	t = t.Snapshot()
+	return radix.WritePrefixes(w, format, createdAt, t.Count(), t.walkPrefixes)
+}
+
+// ImportPrefixes reads alias prefixes from a construct input file or a
+// checkpoint with radix.ReadPrefixes and inserts them into the trie. The
+// imported prefixes are published at once when the import ends.
+func (t *PrefixTrie) ImportPrefixes(r io.Reader, source string) (int, error) {
+	var counter int
+	var err error
+	t.update(func(draft *PrefixTrie) {
+		counter, err = radix.ReadPrefixes(r, source, func(prefix netip.Prefix, info *radix.LeafInfo) {
+			draft.insertPrefix(prefix, info)
+		})
+	})
+	return counter, err
+}
+
+// ImportPrefixFile reads alias prefixes from the given file with ImportPrefixes.
+func (t *PrefixTrie) ImportPrefixFile(filename, source string) (int, error) {
+	fin, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer fin.Close()
+	return t.ImportPrefixes(fin, source)
+}
+
+// Snapshot returns a view of the alias prefixes as of now, with the same
+// settings.
+func (t *PrefixTrie) Snapshot() *PrefixTrie {
+	snapshot := &PrefixTrie{
+		policy:              t.policy,
+		checkpoints:         t.checkpoints,
+		checkpointFrequency: t.checkpointFrequency,
+	}
+	snapshot.root.Store(t.rootNode())
+	snapshot.isChanged.Store(t.isChanged.Load())
+	snapshot.constructionNewAliasFound.Store(t.constructionNewAliasFound.Load())
+	return snapshot
+}
+
+// View implements radix.Store with a snapshot of the trie.
+func (t *PrefixTrie) View() radix.Store {
+	return t.Snapshot()
+}
+
+// ExportCheckpoint writes the alias prefixes to a new checkpoint named after
+// checkpointTime with radix.WriteCheckpoint. The checkpoint is exported from
+// a snapshot, so the trie can be modified meanwhile.
+func (t *PrefixTrie) ExportCheckpoint(checkpointTime time.Time) error {
+	t.writer.Lock()
+	snapshot := t.Snapshot()
+	t.isChanged.Store(false)
+	newAliasFound := t.constructionNewAliasFound.Swap(false)
+	t.writer.Unlock()
+	if err := radix.WriteCheckpoint(snapshot, t.checkpoints, checkpointTime); err != nil {
+		t.isChanged.Store(true)
+		if newAliasFound {
+			t.constructionNewAliasFound.Store(true)
+		}
+		return err
+	}
+	return nil
+}
+
+// SetAggregationPolicy sets the policy used to infer new aliased prefixes
+// when a prefix is inserted.
+func (t *PrefixTrie) SetAggregationPolicy(policy radix.AggregationPolicy) {
+	t.policy = policy
+}
+
+func (t *PrefixTrie) SetCheckpointBaseName(checkpointBaseName string) {
+	t.checkpoints.BaseName = checkpointBaseName
+}
+
+func (t *PrefixTrie) SetCheckpointFrequency(checkpointFrequency float32) {
+	t.checkpointFrequency = checkpointFrequency
+}
+
+// SetCheckpointRetention sets how many of the newest checkpoints are kept and
+// the age after which checkpoints are removed. Zero values disable the limits.
+func (t *PrefixTrie) SetCheckpointRetention(keep int, maxAge time.Duration) {
+	t.checkpoints.Keep = keep
+	t.checkpoints.MaxAge = maxAge
+}
+
+// SetCheckpointFormat sets the format of the exported checkpoints, either
+// radix.FormatText or radix.FormatBinary.
+func (t *PrefixTrie) SetCheckpointFormat(format string) {
+	t.checkpoints.Format = format
+}
+
+func (t *PrefixTrie) GetCheckpointFrequency() float32 {
+	return t.checkpointFrequency
+}
+
+func (t *PrefixTrie) SetChange(val bool) {
+	t.isChanged.Store(val)
+}
+
+func (t *PrefixTrie) IsChanged() bool {
+	return t.isChanged.Load()
+}
+
+func (t *PrefixTrie) CheckConstructionNewAliasFound() bool {
+	return t.constructionNewAliasFound.Load()
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package amt
+
+import (
+	"aliasv6/radix"
+	"bytes"
+	"math/rand"
+	"net/netip"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// randomAliasPrefix returns a prefix in a few /16 prefixes, so that random
+// prefixes overlap, or an IPv4 prefix.
+func randomAliasPrefix(rng *rand.Rand) netip.Prefix {
+	var raw [16]byte
+	rng.Read(raw[:])
+	if rng.Intn(8) == 0 {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte{192, 0, raw[2] & 0x3, raw[3]}), 8+rng.Intn(25)).Masked()
+	}
+	raw[0], raw[1] = 0x20, byte(rng.Intn(2))
+	raw[2] &= 0x0F
+	return netip.PrefixFrom(netip.AddrFrom16(raw), 2+rng.Intn(127)).Masked()
+}
+
+// export returns the checkpoint of a store in the given format.
+func export(t *testing.T, store radix.Store, format string) string {
+	var buf bytes.Buffer
+	if err := store.Export(&buf, format, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestPrefixStoreDefaultPrefix(t *testing.T) {
+	// The default prefix replaces the whole tree in both stores.
+	tree := radix.InitRadix()
+	trie := InitPrefixTrie()
+	seen := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		op       string
+		prefix   string
+		expected interface{}
+	}{
+		{"insert", "2001:db8::/32", radix.InsertResult{Effect: radix.InsertNew, Prefix: netip.MustParsePrefix("2001:db8::/32")}},
+		{"insert", "192.0.2.0/24", radix.InsertResult{Effect: radix.InsertNew, Prefix: netip.MustParsePrefix("192.0.2.0/24")}},
+		{"insert", "::/0", radix.InsertResult{Effect: radix.InsertNew, Prefix: netip.MustParsePrefix("::/0"), Pruned: 2}},
+		{"insert", "::/0", radix.InsertResult{Effect: radix.InsertConfirmed, Prefix: netip.MustParsePrefix("::/0")}},
+		{"insert", "2001::/16", radix.InsertResult{Effect: radix.InsertCovered, Prefix: netip.MustParsePrefix("::/0")}},
+		{"delete", "::/0", true},
+		{"delete", "::/0", false},
+		{"insert", "::/0", radix.InsertResult{Effect: radix.InsertNew, Prefix: netip.MustParsePrefix("::/0")}},
+		{"delete-subtree", "::/0", 1},
+	} {
+		prefix := netip.MustParsePrefix(tt.prefix)
+		var given, expected interface{}
+		switch tt.op {
+		case "insert":
+			expected = tree.InsertWithInfo(prefix, radix.NewLeafInfo(radix.SourceInsert, seen, nil))
+			given = trie.InsertWithInfo(prefix, radix.NewLeafInfo(radix.SourceInsert, seen, nil))
+		case "delete":
+			expected, given = tree.Delete(prefix), trie.Delete(prefix)
+		default:
+			expected, given = tree.DeleteSubtree(prefix), trie.DeleteSubtree(prefix)
+		}
+		if expected != tt.expected || given != expected {
+			t.Fatalf("%s %s: radix returned %+v, amt returned %+v, expected %+v", tt.op, tt.prefix, expected, given, tt.expected)
+		}
+		if given, expected := export(t, trie, radix.FormatText), export(t, tree, radix.FormatText); given != expected {
+			t.Fatalf("%s %s: different alias prefixes:\ngiven:\n%s\nexpected:\n%s", tt.op, tt.prefix, given, expected)
+		}
+		for _, ip := range []string{"1::1", "2001:db8::1", "192.0.2.1"} {
+			addr := netip.MustParseAddr(ip)
+			if given, expected := trie.LookUp(addr), tree.LookUp(addr); !reflect.DeepEqual(given, expected) {
+				t.Fatalf("%s %s: %s: wrong label %+v, expected %+v", tt.op, tt.prefix, ip, given, expected)
+			}
+		}
+		if tt.op == "insert" && tt.prefix == "::/0" && !tree.LookUp(netip.MustParseAddr("1::1")).Aliased {
+			t.Fatalf("%s %s: 1::1 is not aliased", tt.op, tt.prefix)
+		}
+	}
+}
+
+func TestPrefixStore(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	seen := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, policy := range []radix.AggregationPolicy{&radix.SiblingPolicy{}, &radix.NibblePolicy{Threshold: 2}} {
+		tree := radix.InitRadix()
+		tree.SetAggregationPolicy(policy)
+		trie := InitPrefixTrie()
+		trie.SetAggregationPolicy(policy)
+		inserted := []netip.Prefix{}
+		for op := 0; op < 2000; op++ {
+			switch n := rng.Intn(10); {
+			case n < 6 || len(inserted) == 0:
+				prefix := randomAliasPrefix(rng)
+				inserted = append(inserted, prefix)
+				tags := map[string]string{"op": string(rune('a' + op%26))}
+				expected := tree.InsertWithInfo(prefix, radix.NewLeafInfo(radix.SourceInsert, seen.Add(time.Duration(op)), tags))
+				if given := trie.InsertWithInfo(prefix, radix.NewLeafInfo(radix.SourceInsert, seen.Add(time.Duration(op)), tags)); given != expected {
+					t.Fatalf("operation %d: insert %s returned %+v, expected %+v", op, prefix, given, expected)
+				}
+			case n < 8:
+				prefix := inserted[rng.Intn(len(inserted))]
+				if given, expected := trie.Delete(prefix), tree.Delete(prefix); given != expected {
+					t.Fatalf("operation %d: delete %s returned %t, expected %t", op, prefix, given, expected)
+				}
+			default:
+				prefix := randomAliasPrefix(rng)
+				prefix = netip.PrefixFrom(prefix.Addr(), prefix.Bits()/2).Masked()
+				if given, expected := trie.DeleteSubtree(prefix), tree.DeleteSubtree(prefix); given != expected {
+					t.Fatalf("operation %d: delete subtree %s returned %d, expected %d", op, prefix, given, expected)
+				}
+			}
+			if op%50 != 0 {
+				continue
+			}
+			if given, expected := export(t, trie, radix.FormatText), export(t, tree, radix.FormatText); given != expected {
+				t.Fatalf("operation %d: different alias prefixes:\ngiven:\n%s\nexpected:\n%s", op, given, expected)
+			}
+		}
+		if given, expected := trie.Count(), tree.Count(); given != expected {
+			t.Errorf("%d alias prefixes, expected %d", given, expected)
+		}
+		if export(t, trie, radix.FormatBinary) != export(t, tree, radix.FormatBinary) {
+			t.Errorf("different binary checkpoints")
+		}
+		for i := 0; i < 5000; i++ {
+			prefix := randomAliasPrefix(rng)
+			if i%2 == 0 {
+				// Look up the prefixes around the inserted ones.
+				prefix = inserted[rng.Intn(len(inserted))]
+				prefix = netip.PrefixFrom(prefix.Addr(), prefix.Bits()-2+rng.Intn(5)).Masked()
+				if !prefix.IsValid() {
+					continue
+				}
+			}
+			addr := prefix.Addr()
+			if given, expected := trie.LookUp(addr), tree.LookUp(addr); !reflect.DeepEqual(given, expected) {
+				t.Fatalf("%s: wrong label %+v, expected %+v", addr, given, expected)
+			}
+			givenMatch, givenFound := trie.LookUpMatch(addr)
+			expectedMatch, expectedFound := tree.LookUpMatch(addr)
+			if givenFound != expectedFound || givenMatch.Prefix != expectedMatch.Prefix {
+				t.Fatalf("%s: wrong match %s, expected %s", addr, givenMatch.Prefix, expectedMatch.Prefix)
+			}
+			if given, expected := trie.LookUpPrefix(prefix), tree.LookUpPrefix(prefix); !reflect.DeepEqual(given, expected) {
+				t.Fatalf("%s: wrong coverage %+v, expected %+v", prefix, given, expected)
+			}
+			if given, expected := trie.Covering(prefix), tree.Covering(prefix); !reflect.DeepEqual(given, expected) {
+				t.Fatalf("%s: wrong covering prefixes %+v, expected %+v", prefix, given, expected)
+			}
+			if given, expected := trie.CoveredBy(prefix), tree.CoveredBy(prefix); !reflect.DeepEqual(given, expected) {
+				t.Fatalf("%s: wrong covered prefixes %+v, expected %+v", prefix, given, expected)
+			}
+		}
+	}
+}
+
+func TestPrefixSnapshot(t *testing.T) {
+	trie := InitPrefixTrie()
+	trie.Insert(netip.MustParsePrefix("2001:db8::/32"))
+	trie.Insert(netip.MustParsePrefix("192.0.2.0/24"))
+	view := trie.View()
+	trie.Insert(netip.MustParsePrefix("2001:db9::/48"))
+	trie.DeleteSubtree(netip.MustParsePrefix("::/0"))
+	if trie.Count() != 0 || view.Count() != 2 {
+		t.Fatalf("the view has %d alias prefixes and the trie %d, expected 2 and 0", view.Count(), trie.Count())
+	}
+	for _, tt := range []struct {
+		addr     string
+		expected string
+	}{
+		{"2001:db8::1", "2001:db8::/32"},
+		{"192.0.2.1", "192.0.2.0/24"},
+		{"::ffff:192.0.2.1", "192.0.2.0/24"},
+		{"2001:db9::1", ""},
+	} {
+		if label := view.LookUp(netip.MustParseAddr(tt.addr)); label.Metadata != tt.expected {
+			t.Errorf("%s: wrong alias prefix %q, expected %q", tt.addr, label.Metadata, tt.expected)
+		}
+	}
+	addr := netip.MustParseAddr("2001:db8::1")
+	if allocs := testing.AllocsPerRun(1000, func() { view.LookUpMatch(addr) }); allocs != 0 {
+		t.Errorf("%.1f allocations per lookup, expected none", allocs)
+	}
+}
+
+// randomStores returns a radix tree and a prefix trie with the same random alias
+// prefixes, between /32 and /64 as in the radix benchmarks.
+func randomStores(rng *rand.Rand, size int) (*radix.Radix, *PrefixTrie) {
+	tree := radix.InitRadix()
+	trie := InitPrefixTrie()
+	seen := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < size; i++ {
+		var raw [16]byte
+		rng.Read(raw[:])
+		prefix := netip.PrefixFrom(netip.AddrFrom16(raw), 32+rng.Intn(33)).Masked()
+		tree.InsertWithInfo(prefix, radix.NewLeafInfo(radix.SourceInsert, seen, nil))
+		trie.InsertWithInfo(prefix, radix.NewLeafInfo(radix.SourceInsert, seen, nil))
+	}
+	return tree, trie
+}
+
+func BenchmarkPrefixLookUp(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	tree, trie := randomStores(rng, 100000)
+	addrs := make([]netip.Addr, 1024)
+	for i := range addrs {
+		var raw [16]byte
+		rng.Read(raw[:])
+		addrs[i] = netip.AddrFrom16(raw)
+	}
+	for _, bb := range []struct {
+		name  string
+		store radix.Store
+	}{
+		{"radix", tree},
+		{"amt", trie},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				bb.store.LookUpMatch(addrs[i%len(addrs)])
+			}
+		})
+	}
+}
+
+func BenchmarkPrefixMemoryPerMillionPrefixes(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		trie := InitPrefixTrie()
+		rng := rand.New(rand.NewSource(int64(i)))
+		seen := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		for j := 0; j < 1000000; j++ {
+			var raw [16]byte
+			rng.Read(raw[:])
+			prefix := netip.PrefixFrom(netip.AddrFrom16(raw), 32+rng.Intn(33)).Masked()
+			trie.InsertWithInfo(prefix, radix.NewLeafInfo(radix.SourceInsert, seen, nil))
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/(1<<20), "MiB")
+		b.ReportMetric(float64(trie.Count()), "prefixes")
+		runtime.KeepAlive(trie)
+	}
+}
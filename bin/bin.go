@@ -18,18 +18,21 @@ package bin
 
 import (
 	"aliasv6"
+	"aliasv6/amt"
 	"aliasv6/radix"
 	"aliasv6/stats"
 	"aliasv6/stress"
-	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
-	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/pprof"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	flags "github.com/jessevdk/go-flags"
@@ -122,35 +125,128 @@ func Test(testType, constructInputFile, inputFile, outputFile string, stepSize i
 	}
 }
 
-func constructRadixTree(constructInputFile string, checkpointBaseName string, checkpointFrequency float32) *radix.Radix {
-
-	fin, err := os.Open(constructInputFile)
-	check(err)
-	defer fin.Close()
-
-	l := radix.InitRadix()
-	l.SetCheckpointBaseName(checkpointBaseName)
-	l.SetCheckpointFrequency(checkpointFrequency)
-
-	scanner := bufio.NewScanner(fin)
-	scanner.Split(bufio.ScanLines)
+// getAggregationPolicy returns the aggregation policy selected in the config.
+func getAggregationPolicy(config aliasv6.Config) radix.AggregationPolicy {
+	switch config.AggregationPolicy {
+	case "nibble":
+		return &radix.NibblePolicy{Threshold: config.AggregationThreshold, MinLength: config.AggregationMinLength}
+	default:
+		return &radix.SiblingPolicy{MinLength: config.AggregationMinLength}
+	}
+}
 
-	for scanner.Scan() {
-		parsedIP, parsedNetwork, err := net.ParseCIDR(scanner.Text())
+// constructStore returns the store of the backend selected in the config,
+// with the alias prefixes of the newest checkpoint (with --resume) and of
+// the construct input file.
+func constructStore(config aliasv6.Config) radix.Store {
+	var store radix.Store
+	switch config.Backend {
+	case "amt":
+		store = amt.InitPrefixTrie()
+	default:
+		store = radix.InitRadix()
+	}
+	store.SetCheckpointBaseName(config.CheckpointBaseName)
+	store.SetCheckpointFrequency(config.CheckpointFrequency)
+	store.SetAggregationPolicy(getAggregationPolicy(config))
+	store.SetCheckpointRetention(config.CheckpointKeep, config.CheckpointMaxAge)
+	store.SetCheckpointFormat(config.CheckpointFormat)
+
+	if config.Resume {
+		checkpointFile, checkpointTime, err := radix.FindLatestCheckpoint(config.CheckpointBaseName)
 		check(err)
-		if parsedIP == nil || parsedNetwork == nil {
-			log.Warnf("couldn't parse input %s", scanner.Text())
-			continue
+		if checkpointFile == "" {
+			log.Warnf("no checkpoint found with base name %s, starting with an empty tree", config.CheckpointBaseName)
+		} else {
+			restored, err := store.ImportPrefixFile(checkpointFile, radix.SourceConstruct)
+			check(err)
+			log.Infof("restored %d alias prefixes from checkpoint %s created at %s", restored, checkpointFile, checkpointTime.Format(time.RFC3339))
 		}
-		l.Insert(parsedNetwork)
 	}
-	l.SetChange(false)
-	if l.CheckConstructionNewAliasFound() {
+	if config.ConstructInputFile != "" {
+		constructed, err := store.ImportPrefixFile(config.ConstructInputFile, radix.SourceConstruct)
+		check(err)
+		log.Infof("inserted %d alias prefixes from %s", constructed, config.ConstructInputFile)
+	}
+	store.SetChange(false)
+	if store.CheckConstructionNewAliasFound() {
 		exportTime := time.Now()
-		log.Infof("found new aliases while constructing the tree. exporting new prefixes to checkpoint-%s", exportTime.Format(time.RFC3339))
-		l.ExportCheckpoint(exportTime)
+		log.Infof("found new aliases while constructing the tree. exporting new prefixes to %s-%s", config.CheckpointBaseName, exportTime.Format(time.RFC3339))
+		if err := store.ExportCheckpoint(exportTime); err != nil {
+			log.Error(err)
+		}
+	}
+	if tree, ok := store.(*radix.Radix); ok {
+		// The table is built once the tree is constructed rather than
+		// updated with each imported prefix.
+		tree.SetFrontTable(config.FrontTableBits)
+	}
+	return store
+}
+
+// openJournal opens the journal of the mutating commands. Unless the tree
+// is resumed from a checkpoint, the journal of the previous run is
+// discarded.
+func openJournal(config aliasv6.Config) *aliasv6.Journal {
+	journalFile := config.JournalFile
+	if journalFile == "" {
+		journalFile = fmt.Sprintf("%s.journal", config.CheckpointBaseName)
+	}
+	journal, err := aliasv6.OpenJournal(journalFile)
+	check(err)
+	if !config.Resume {
+		if info, err := os.Stat(journalFile); err == nil && info.Size() > 0 {
+			log.Warnf("discarding the journal %s of a previous run, use --resume to replay it", journalFile)
+		}
+		check(journal.Truncate())
+	}
+	return journal
+}
+
+// serve runs the sessions of the clients connecting to the listen address
+// of the serve command until the process is interrupted.
+func serve(config aliasv6.Config, engine *aliasv6.Engine) {
+	listener, err := aliasv6.Listen(config.Serve.Listen)
+	check(err)
+	server := aliasv6.NewServer(engine)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		sig := <-signals
+		log.Infof("received %s, closing the sessions", sig)
+		server.Close()
+	}()
+	if err := server.Serve(listener); err != nil {
+		log.Fatal(err)
+	}
+	server.Close()
+}
+
+// serveHTTP serves the HTTP API on the listen address of the http command
+// until the process is interrupted.
+func serveHTTP(config aliasv6.Config, engine *aliasv6.Engine) {
+	listener, err := aliasv6.Listen(config.HTTP.Listen)
+	check(err)
+	server := &http.Server{Handler: aliasv6.NewHTTPHandler(engine)}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		sig := <-signals
+		log.Infof("received %s, shutting down the HTTP API", sig)
+		if err := server.Shutdown(context.Background()); err != nil {
+			log.Error(err)
+		}
+	}()
+	log.Infof("serving the HTTP API on %s://%s", listener.Addr().Network(), listener.Addr())
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		log.Fatal(err)
 	}
-	return l
+	// Serve returns once Shutdown is called, before the requests are done.
+	<-shutdown
 }
 
 // AliasV6Main should be called by func main() in a binary. The caller is
@@ -184,6 +280,15 @@ func AliasV6Main() {
 		return
 	}
 
+	switch config.Command {
+	case "diff":
+		Diff(config)
+		return
+	case "merge":
+		Merge(config)
+		return
+	}
+
 	// Need an operator to receive commands from Generator
 	// Operator Tasks:
 	// 1. Construct the tree from the input file and initiate the timer for checkpoint checks
@@ -197,7 +302,14 @@ func AliasV6Main() {
 	//    stdin.
 
 	// Construct the tree from the input file
-	l := constructRadixTree(config.ConstructInputFile, config.CheckpointBaseName, config.CheckpointFrequency)
+	l := constructStore(config)
+
+	// Replay the commands since the last checkpoint, and record new ones
+	var journal *aliasv6.Journal
+	if !config.DisableJournal {
+		journal = openJournal(config)
+		defer journal.Close()
+	}
 
 	// Set up a monitor to keep track of successes and failures
 	wg := sync.WaitGroup{}
@@ -206,89 +318,27 @@ func AliasV6Main() {
 		dumpHeapProfile()
 	}
 
-	start := time.Now()
-	log.Infof("started dealiasing at %s", start.Format(time.RFC3339))
-
-	processQueue := make(chan aliasv6.Command, config.NumLookUpWorkers*4)
-	outputQueue := make(chan []byte, config.NumLookUpWorkers*4)
-
-	mutex := &sync.RWMutex{}
-
-	// Create wait groups
-	var lookupWorkerDone sync.WaitGroup
-	var outputDone sync.WaitGroup
-	lookupWorkerDone.Add(config.NumLookUpWorkers)
-	outputDone.Add(1)
-
-	// Initiate the checkpoint goroutine
-	var timerWorkerDone sync.WaitGroup
-	timerWorkerDone.Add(1)
-	ticker := time.NewTicker(time.Duration(l.GetCheckpointFrequency() * float32(time.Second)))
-	quitTimerChannel := make(chan struct{})
-	go func(mux *sync.RWMutex) {
-		for {
-			select {
-			case <-ticker.C:
-				ticker.Stop()
-				mux.RLock()
-				if l.IsChanged() {
-					checkpointTime := time.Now()
-					log.Infof("detected changes in the tree, creating a checkpoint at %s", checkpointTime.Format(time.RFC3339))
-					l.ExportCheckpoint(checkpointTime)
-				}
-				mux.RUnlock()
-				ticker.Reset(time.Duration(l.GetCheckpointFrequency() * float32(time.Second)))
-			case <-quitTimerChannel:
-				ticker.Stop()
-				timerWorkerDone.Done()
-				return
-			}
-		}
-	}(mutex)
-
-	// Start the output encoder
-	go func() {
-		defer outputDone.Done()
-		if err := config.OutputResults(outputQueue); err != nil {
-			log.Fatal(err)
+	engine := aliasv6.NewEngine(l, journal, monitor)
+	if config.Resume && journal != nil {
+		replayed, err := engine.Replay()
+		check(err)
+		if replayed > 0 {
+			log.Infof("replayed %d commands from the journal %s", replayed, journal.Path())
 		}
-	}()
-
-	// Start all the lookup workers
-	for i := 0; i < config.NumLookUpWorkers; i++ {
-		go func(mux *sync.RWMutex) {
-			for obj := range processQueue {
-				if obj.Type == "lookup" {
-					mux.RLock()
-					raw := aliasv6.RunLookUp(l, monitor, obj.ParsedData.(net.IP), config.Expanded)
-					mux.RUnlock()
-					result, err := json.Marshal(raw)
-					if err != nil {
-						log.Fatalf("unable to marshal data: %s", err)
-					}
-					outputQueue <- result
-				} else if obj.Type == "insert" {
-					mux.Lock()
-					log.Infof("inserting %s", obj.ParsedData.(*net.IPNet))
-					l.Insert(obj.ParsedData.(*net.IPNet))
-					mux.Unlock()
-				} else if obj.Type == "quit" {
-					break
-				}
-			}
-			lookupWorkerDone.Done()
-		}(mutex)
 	}
 
-	if err := config.InputTargets(processQueue); err != nil {
+	start := time.Now()
+	log.Infof("started dealiasing at %s", start.Format(time.RFC3339))
+
+	stopCheckpoints := engine.StartCheckpoints()
+	if config.Command == "serve" {
+		serve(config, engine)
+	} else if config.Command == "http" {
+		serveHTTP(config, engine)
+	} else if err := engine.RunSession(config.InputTargets, config.OutputResults); err != nil {
 		log.Fatal(err)
 	}
-	close(processQueue)
-	lookupWorkerDone.Wait()
-	close(outputQueue)
-	outputDone.Wait()
-	close(quitTimerChannel)
-	timerWorkerDone.Wait()
+	stopCheckpoints()
 
 	end := time.Now()
 	log.Infof("finished dealiasing at %s", end.Format(time.RFC3339))
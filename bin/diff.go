@@ -0,0 +1,64 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bin
+
+import (
+	"aliasv6"
+	"aliasv6/radix"
+	"bufio"
+	"encoding/json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DiffResult is the last line of the diff output.
+type DiffResult struct {
+	Old     string            `json:"old"`
+	New     string            `json:"new"`
+	Summary radix.DiffSummary `json:"summary"`
+}
+
+// loadTree builds a tree from a checkpoint or a prefix file, aggregating its
+// prefixes with the given policy.
+func loadTree(filename string, policy radix.AggregationPolicy) *radix.Radix {
+	l := radix.InitRadix()
+	l.SetAggregationPolicy(policy)
+	loaded, err := l.ImportPrefixFile(filename, radix.SourceConstruct)
+	check(err)
+	log.Infof("loaded %d alias prefixes from %s", loaded, filename)
+	return l
+}
+
+// Diff is called when the user runs the diff command. It writes one JSON
+// line per change between the two checkpoints, followed by the summary.
+func Diff(config aliasv6.Config) {
+	// The prefixes are not aggregated, so the trees have the prefixes of
+	// the files whichever policy they were written with.
+	oldTree := loadTree(config.Diff.Args.Old, &radix.NoAggregationPolicy{})
+	newTree := loadTree(config.Diff.Args.New, &radix.NoAggregationPolicy{})
+
+	buf := bufio.NewWriter(aliasv6.GetOutputFile())
+	defer buf.Flush()
+	enc := json.NewEncoder(buf)
+	summary := radix.Diff(oldTree, newTree, func(change radix.Change) {
+		if err := enc.Encode(&change); err != nil {
+			log.Fatalf("unable to write change: %s", err)
+		}
+	})
+	if err := enc.Encode(&DiffResult{Old: config.Diff.Args.Old, New: config.Diff.Args.New, Summary: summary}); err != nil {
+		log.Fatalf("unable to write summary: %s", err)
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bin
+
+import (
+	"aliasv6"
+	"aliasv6/radix"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Merge is called when the user runs the merge command. It combines the
+// given files with the selected set operation from left to right and writes
+// the result to the output file in the checkpoint format.
+func Merge(config aliasv6.Config) {
+	var operation func(a, b *radix.Radix) *radix.Radix
+	switch config.Merge.Operation {
+	case "intersect":
+		operation = radix.Intersect
+	case "difference":
+		operation = radix.Difference
+	default:
+		operation = radix.Union
+	}
+	files := config.Merge.Args.Files
+	result := loadTree(files[0], &radix.SiblingPolicy{})
+	for _, filename := range files[1:] {
+		result = operation(result, loadTree(filename, &radix.SiblingPolicy{}))
+	}
+	log.Infof("%s of %d files has %d alias prefixes", config.Merge.Operation, len(files), result.Count())
+	if err := result.Export(aliasv6.GetOutputFile(), config.CheckpointFormat, time.Now()); err != nil {
+		log.Fatalf("unable to write the merged prefixes: %s", err)
+	}
+}
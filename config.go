@@ -18,7 +18,9 @@ limitations under the License.
 package aliasv6
 
 import (
+	"aliasv6/radix"
 	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -26,21 +28,42 @@ import (
 // Config is the high level framework options that will be parsed
 // from the command line
 type Config struct {
-	OutputFileName      string  `short:"o" long:"output-file" default:"-" description:"Output filename, use - for stdout"`
-	InputFileName       string  `short:"f" long:"input-file" default:"-" description:"Input filename, use - for stdin"`
-	MetaFileName        string  `short:"m" long:"metadata-file" default:"-" description:"Metadata filename, use - for stderr"`
-	LogFileName         string  `short:"l" long:"log-file" default:"-" description:"Log filename, use - for stderr"`
-	ConstructInputFile  string  `short:"c" long:"construct-input-file" description:"List of ips/prefixes input file to construct Tree/Trie for tests"`
-	CheckpointBaseName  string  `long:"checkpoint-base-name" default:"checkpoint" description:"Base name for the Tree/Trie checkpoints if there is a change. It will be followed by the timestamp of the checkpoint"`
-	CheckpointFrequency float32 `long:"checkpoint-frequency" default:"30.0" description:"Frequency in seconds to export Tree/Trie checkpoints"`
-	TestType            string  `long:"test-type" default:"radix" choice:"radix" choice:"stats" choice:"stress" description:"Testing mode"`
-	TestInputFile       string  `long:"test-input-file" description:"List of ips input file for Tree/Trie for tests (e.x. lookup tests for radix)"`
-	TestOutputFile      string  `long:"test-output-file" description:"File to export results of stats test mode"`
-	Test                bool    `short:"t" long:"test" description:"Set program to testing mode"`
-	Flush               bool    `long:"flush" description:"Flush after each line of output."`
-	Expanded            bool    `long:"expanded" description:"Print IPs in an expanded format"`
-	TestStepSize        int     `long:"test-step-size" default:"1000000" description:"Checkpoint or logging step size for tests"`
-	NumLookUpWorkers    int     `long:"num-lookup-workers" default:"1000" description:"Number of workers to perform concurrent lookup operations"`
+	OutputFileName       string        `short:"o" long:"output-file" default:"-" description:"Output filename, use - for stdout"`
+	InputFileName        string        `short:"f" long:"input-file" default:"-" description:"Input filename, use - for stdin"`
+	MetaFileName         string        `short:"m" long:"metadata-file" default:"-" description:"Metadata filename, use - for stderr"`
+	LogFileName          string        `short:"l" long:"log-file" default:"-" description:"Log filename, use - for stderr"`
+	ConstructInputFile   string        `short:"c" long:"construct-input-file" description:"List of ips/prefixes input file to construct Tree/Trie for tests"`
+	CheckpointBaseName   string        `long:"checkpoint-base-name" default:"checkpoint" description:"Base name for the Tree/Trie checkpoints if there is a change. It will be followed by the timestamp of the checkpoint"`
+	CheckpointFormat     string        `long:"checkpoint-format" default:"text" choice:"text" choice:"binary" description:"Format of the exported checkpoints. The format is detected automatically when a checkpoint is loaded"`
+	JournalFile          string        `long:"journal-file" description:"Journal of the insert and delete commands received since the last checkpoint, replayed with --resume (default: <checkpoint-base-name>.journal)"`
+	DisableJournal       bool          `long:"disable-journal" description:"Do not record insert and delete commands in a journal"`
+	Resume               bool          `long:"resume" description:"Restore the tree from the newest checkpoint with the checkpoint base name. The construct input file is inserted on top of it if given"`
+	CheckpointFrequency  float32       `long:"checkpoint-frequency" default:"30.0" description:"Frequency in seconds to export Tree/Trie checkpoints"`
+	CheckpointKeep       int           `long:"checkpoint-keep" default:"0" description:"Number of newest checkpoints to keep, older ones are removed. 0 keeps every checkpoint"`
+	CheckpointMaxAge     time.Duration `long:"checkpoint-max-age" default:"0s" description:"Remove checkpoints older than the given duration (e.g. 72h). 0s keeps every checkpoint"`
+	AggregationPolicy    string        `long:"aggregation-policy" default:"sibling" choice:"sibling" choice:"nibble" description:"Policy to infer new alias prefixes on insertion. sibling: both halves of a prefix are aliased, nibble: enough of the 16 nibble sub-prefixes of a prefix are aliased"`
+	AggregationThreshold int           `long:"aggregation-threshold" default:"16" description:"Minimum number of aliased sub-prefixes (out of 16) to infer the parent prefix in nibble aggregation policy"`
+	AggregationMinLength int           `long:"aggregation-min-length" default:"0" description:"Shortest prefix length that can be inferred as aliased by the aggregation policy"`
+	FrontTableBits       int           `long:"front-table-bits" default:"0" description:"Index lookups with a direct-indexed table on the given number of leading address bits (up to 24, e.g. 16 or 24) in front of the tree. It takes 2^bits pointers of memory. 0 disables the table"`
+	Backend              string        `long:"backend" default:"radix" choice:"radix" choice:"amt" description:"Data structure holding the alias prefixes: a path-compressed radix tree, or an array mapped trie on 4-bit strides with faster lookups but more memory for sparse prefixes"`
+	TestType             string        `long:"test-type" default:"radix" choice:"radix" choice:"stats" choice:"stress" description:"Testing mode"`
+	TestInputFile        string        `long:"test-input-file" description:"List of ips input file for Tree/Trie for tests (e.x. lookup tests for radix)"`
+	TestOutputFile       string        `long:"test-output-file" description:"File to export results of stats test mode"`
+	Test                 bool          `short:"t" long:"test" description:"Set program to testing mode"`
+	Flush                bool          `long:"flush" description:"Flush after each line of output."`
+	Expanded             bool          `long:"expanded" description:"Print IPs in an expanded format"`
+	ExpandPrefixes       bool          `long:"expand-prefixes" description:"Look up every address of a prefix given in a lookup command instead of reporting the coverage of the prefix"`
+	MaxExpansion         uint64        `long:"max-expansion" default:"65536" description:"Largest number of addresses a prefix can be expanded into with --expand-prefixes. Larger prefixes are skipped"`
+	TestStepSize         int           `long:"test-step-size" default:"1000000" description:"Checkpoint or logging step size for tests"`
+	NumLookUpWorkers     int           `long:"num-lookup-workers" default:"1000" description:"Number of workers to perform concurrent lookup operations"`
+	Ordered              bool          `long:"ordered" description:"Write the results in the order of the commands instead of the order they are ready in"`
+	ReorderWindow        int           `long:"reorder-window" default:"65536" description:"Largest number of results held to be written in order with --ordered. Lookups wait when a result is that far ahead of the oldest pending one"`
+	Diff                 DiffCommand   `command:"diff" description:"Compare two checkpoints and report the changes in JSON lines"`
+	Merge                MergeCommand  `command:"merge" description:"Combine prefix files with a set operation and write the result as a checkpoint to the output file"`
+	Serve                ServeCommand  `command:"serve" description:"Accept sessions on a TCP or Unix socket. Each connection has its own command stream on the shared tree"`
+	HTTP                 HTTPCommand   `command:"http" description:"Serve an HTTP/JSON API for lookups, inserts, deletes and checkpoints of the tree"`
+	// Command is the name of the selected command, empty in the default dealiasing mode.
+	Command string
 	// InputType           string  `long:"input-type" default:"command" choice:"command" choice:"ip" description:"Input feed type. Command has to be in JSON format, and ip is a IPv6 address as a string."`
 	inputFile     *os.File
 	outputFile    *os.File
@@ -50,6 +73,32 @@ type Config struct {
 	OutputResults OutputResultsFunc
 }
 
+// DiffCommand holds the arguments of the diff command.
+type DiffCommand struct {
+	Args struct {
+		Old string `positional-arg-name:"old" description:"Old checkpoint or prefix file"`
+		New string `positional-arg-name:"new" description:"New checkpoint or prefix file"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// MergeCommand holds the options and arguments of the merge command.
+type MergeCommand struct {
+	Operation string `long:"operation" default:"union" choice:"union" choice:"intersect" choice:"difference" description:"Set operation to combine the files with. difference removes the prefixes of the other files from the first one"`
+	Args      struct {
+		Files []string `positional-arg-name:"files" required:"2" description:"Checkpoints or prefix files to combine"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// ServeCommand holds the options of the serve command.
+type ServeCommand struct {
+	Listen string `long:"listen" required:"yes" description:"Address to accept sessions on: tcp://host:port or unix:///path"`
+}
+
+// HTTPCommand holds the options of the http command.
+type HTTPCommand struct {
+	Listen string `long:"listen" required:"yes" description:"Address to serve the HTTP API on: tcp://host:port or unix:///path"`
+}
+
 var config Config
 
 // SetInputFunc sets the target input function to the provided function.
@@ -113,10 +162,49 @@ func validateFrameworkConfiguration() {
 		}
 	}
 
+	if config.CheckpointKeep < 0 {
+		log.Fatalf("number of checkpoints to keep cannot be negative, given %d", config.CheckpointKeep)
+	}
+
+	if (config.Command == "" || config.Command == "serve" || config.Command == "http") && !config.Test && !config.Resume && config.ConstructInputFile == "" {
+		log.Fatal("construct input file should be provided unless resuming from a checkpoint")
+	}
+	if config.Command == "serve" {
+		if _, _, err := ParseListenAddress(config.Serve.Listen); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if config.Command == "http" {
+		if _, _, err := ParseListenAddress(config.HTTP.Listen); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.AggregationThreshold < 1 || config.AggregationThreshold > 16 {
+		log.Fatalf("aggregation threshold should be between 1 and 16, given %d", config.AggregationThreshold)
+	}
+	if config.AggregationMinLength < 0 || config.AggregationMinLength > 128 {
+		log.Fatalf("aggregation min length should be between 0 and 128, given %d", config.AggregationMinLength)
+	}
+
+	if config.FrontTableBits < 0 || config.FrontTableBits > radix.MaxFrontTableBits {
+		log.Fatalf("front table bits should be between 0 and %d, given %d", radix.MaxFrontTableBits, config.FrontTableBits)
+	}
+	if config.FrontTableBits > 0 && config.Backend != "radix" {
+		log.Fatalf("front table is only supported with the radix backend, given %s", config.Backend)
+	}
+
+	if config.ExpandPrefixes && config.MaxExpansion == 0 {
+		log.Fatal("max expansion should be positive when expanding prefixes")
+	}
+
 	//validate senders
 	if config.NumLookUpWorkers <= 0 {
 		log.Fatalf("need at least one lookup worker, given %d", config.NumLookUpWorkers)
 	}
+	if config.Ordered && config.ReorderWindow <= 0 {
+		log.Fatalf("reorder window should be positive, given %d", config.ReorderWindow)
+	}
 }
 
 // GetInputFile returns the file to which the program receives input from
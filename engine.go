@@ -0,0 +1,292 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package aliasv6
+
+import (
+	"aliasv6/radix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Engine runs the commands of one or more sessions on a shared store. The
+// store publishes each modification at once, so lookups need no lock. The
+// mutex keeps the journal in the order the modifications are applied.
+type Engine struct {
+	store   radix.Store
+	journal *Journal
+	mutex   sync.Mutex
+	monitor *Monitor
+	// checkpointMutex runs one checkpoint at a time, so that the journal
+	// entries are discarded in order.
+	checkpointMutex sync.Mutex
+}
+
+// NewEngine returns an Engine running commands on store, which records the
+// modifications in journal (unless it is nil) and reports the lookups to
+// monitor.
+func NewEngine(store radix.Store, journal *Journal, monitor *Monitor) *Engine {
+	return &Engine{store: store, journal: journal, monitor: monitor}
+}
+
+// insertPrefix adds an alias prefix received with an insert command to the
+// store and returns the effect of the insertion.
+func (e *Engine) insertPrefix(prefix netip.Prefix, tags map[string]string, when time.Time) radix.InsertResult {
+	result := e.store.InsertWithInfo(prefix, radix.NewLeafInfo(radix.SourceInsert, when, tags))
+	log.Infof("inserted %s: %s %s", prefix, result.Effect, result.Prefix)
+	return result
+}
+
+// deletePrefix removes an alias prefix received with a delete command from
+// the store and returns the number of removed alias prefixes.
+func (e *Engine) deletePrefix(prefix netip.Prefix, recursive bool) int {
+	if recursive {
+		removed := e.store.DeleteSubtree(prefix)
+		log.Infof("deleted %d prefixes under %s", removed, prefix)
+		return removed
+	}
+	if e.store.Delete(prefix) {
+		log.Infof("deleted %s", prefix)
+		return 1
+	}
+	log.Warnf("cannot delete %s, it is not an alias prefix in the tree", prefix)
+	return 0
+}
+
+// Replay applies the commands of the journal, which were received after
+// the checkpoint the store is restored from. It returns the number of
+// replayed commands.
+func (e *Engine) Replay() (int, error) {
+	if e.journal == nil {
+		return 0, nil
+	}
+	return e.journal.Replay(func(entry JournalEntry) error {
+		_, prefix, err := ParseTarget(entry.Data)
+		if err != nil {
+			return err
+		}
+		if !prefix.IsValid() {
+			return fmt.Errorf("journal entry is not a prefix: %s", entry.Data)
+		}
+		switch entry.Type {
+		case "insert":
+			e.insertPrefix(prefix, entry.Tags, entry.Time)
+		case "delete":
+			e.deletePrefix(prefix, entry.Recursive)
+		default:
+			return fmt.Errorf("unknown journal entry type: %s", entry.Type)
+		}
+		return nil
+	})
+}
+
+// apply records an insert or delete command in the journal and applies it
+// to the store. It returns the acknowledgement of the command.
+func (e *Engine) apply(command Command) Ack {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	now := time.Now()
+	if e.journal != nil {
+		if err := e.journal.Append(command, now); err != nil {
+			log.Errorf("cannot record %s %s in the journal, skipping: %v", command.Type, command.Data, err)
+			return NewAck(command, err, now)
+		}
+	}
+	if command.Type == "insert" {
+		ack := NewAck(command, nil, now)
+		ack.Result = e.insertPrefix(command.ParsedData.(netip.Prefix), command.Tags, now)
+		return ack
+	}
+	removed := e.deletePrefix(command.ParsedData.(netip.Prefix), command.Recursive)
+	var err error
+	if removed == 0 {
+		err = NewLookUpError(LOOKUP_NO_MATCH, errors.New("no alias prefix to delete"))
+	}
+	ack := NewAck(command, err, now)
+	ack.Removed = removed
+	return ack
+}
+
+// encodeResult returns the JSON encoding of a response.
+func encodeResult(response interface{}) []byte {
+	result, err := json.Marshal(response)
+	if err != nil {
+		log.Fatalf("unable to marshal data: %s", err)
+	}
+	return result
+}
+
+// Run runs a command delivered by GetTargets and returns the JSON encoding
+// of its response, in a buffer of GetResultBuffer.
+func (e *Engine) Run(command Command) []byte {
+	if command.Err != nil {
+		return encodeResult(NewAck(command, command.Err, time.Now()))
+	}
+	switch command.Type {
+	case "lookup":
+		return AppendLookUp(GetResultBuffer(), e.store, e.monitor, command.ID, command.ParsedData.(netip.Addr), config.Expanded)
+	case "lookup-batch":
+		return AppendLookUpBatch(GetResultBuffer(), e.store, e.monitor, command.ID, command.Targets, command.ParsedData.([]netip.Addr), config.Expanded)
+	case "lookup-prefix":
+		return encodeResult(RunLookUpPrefix(e.store, e.monitor, command.ID, command.ParsedData.(netip.Prefix), config.Expanded))
+	case "covering", "subtree":
+		return encodeResult(RunPrefixQuery(e.store, e.monitor, command.ID, command.Type, command.ParsedData.(netip.Prefix), config.Expanded))
+	case "insert", "delete":
+		return encodeResult(e.apply(command))
+	default:
+		return encodeResult(NewAck(command, nil, time.Now()))
+	}
+}
+
+// RunSession runs the commands delivered by input on NumLookUpWorkers
+// workers and writes their responses with output. The responses are written
+// in the order of the commands with --ordered. It returns once the input
+// ends or a quit command is received, and every response is written.
+func (e *Engine) RunSession(input InputTargetsFunc, output OutputResultsFunc) error {
+	processQueue := make(chan Command, config.NumLookUpWorkers*4)
+	outputQueue := make(chan []byte, config.NumLookUpWorkers*4)
+	// The quit command is acknowledged after every other response.
+	quitCommand := make(chan Command, 1)
+
+	var outputErr error
+	var outputDone sync.WaitGroup
+	outputDone.Add(1)
+	go func() {
+		defer outputDone.Done()
+		outputErr = output(outputQueue)
+	}()
+
+	// With --ordered, the results go through a reorder buffer.
+	var reorderer *Reorderer
+	if config.Ordered {
+		reorderer = NewReorderer(config.ReorderWindow, outputQueue, e.monitor)
+	}
+	emit := func(command Command, result []byte) {
+		if reorderer != nil {
+			reorderer.Put(command.Seq, result)
+		} else if result != nil {
+			outputQueue <- result
+		}
+	}
+
+	var workersDone sync.WaitGroup
+	workersDone.Add(config.NumLookUpWorkers)
+	for i := 0; i < config.NumLookUpWorkers; i++ {
+		go func() {
+			defer workersDone.Done()
+			for command := range processQueue {
+				if command.Type == "quit" && command.Err == nil {
+					quitCommand <- command
+					return
+				}
+				emit(command, e.Run(command))
+			}
+		}()
+	}
+
+	inputErr := input(processQueue)
+	close(processQueue)
+	workersDone.Wait()
+	select {
+	case command := <-quitCommand:
+		emit(command, e.Run(command))
+	default:
+	}
+	close(outputQueue)
+	outputDone.Wait()
+	if inputErr != nil {
+		return inputErr
+	}
+	return outputErr
+}
+
+// Checkpoint exports a checkpoint of the store if it changed since the
+// last one, and discards the journal entries it persists. It returns the
+// time of the checkpoint, or the zero time if the store did not change.
+func (e *Engine) Checkpoint() (time.Time, error) {
+	e.checkpointMutex.Lock()
+	defer e.checkpointMutex.Unlock()
+	// Take a snapshot along with the end of the journal, so the journal
+	// entries it persists are known. Modifications go on while the
+	// snapshot is exported.
+	e.mutex.Lock()
+	if !e.store.IsChanged() {
+		e.mutex.Unlock()
+		return time.Time{}, nil
+	}
+	var journalOffset int64
+	if e.journal != nil {
+		var err error
+		if journalOffset, err = e.journal.Offset(); err != nil {
+			e.mutex.Unlock()
+			return time.Time{}, fmt.Errorf("cannot read the journal offset: %w", err)
+		}
+	}
+	snapshot := e.store.View()
+	e.store.SetChange(false)
+	e.mutex.Unlock()
+
+	checkpointTime := time.Now()
+	log.Infof("detected changes in the tree, creating a checkpoint at %s", checkpointTime.Format(time.RFC3339))
+	if err := snapshot.ExportCheckpoint(checkpointTime); err != nil {
+		e.store.SetChange(true)
+		return time.Time{}, err
+	}
+	if e.journal != nil {
+		if err := e.journal.Discard(journalOffset); err != nil {
+			log.Errorf("cannot discard the checkpointed journal entries: %v", err)
+		}
+	}
+	return checkpointTime, nil
+}
+
+// StartCheckpoints creates a checkpoint at the checkpoint frequency of the
+// store whenever it changed. It returns a function which stops the
+// checkpoints.
+func (e *Engine) StartCheckpoints() (stop func()) {
+	frequency := func() time.Duration {
+		return time.Duration(e.store.GetCheckpointFrequency() * float32(time.Second))
+	}
+	ticker := time.NewTicker(frequency())
+	quit := make(chan struct{})
+	var done sync.WaitGroup
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		for {
+			select {
+			case <-ticker.C:
+				ticker.Stop()
+				if _, err := e.Checkpoint(); err != nil {
+					log.Errorf("%v, retrying at the next checkpoint", err)
+				}
+				ticker.Reset(frequency())
+			case <-quit:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		done.Wait()
+	}
+}
@@ -5,11 +5,8 @@ go 1.20
 require (
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/sirupsen/logrus v1.9.3
-	inet.af/netaddr v0.0.0-20230525184311-b8eac61e914a
 )
 
 require (
-	go4.org/intern v0.0.0-20230525184215-6c62f75575cb // indirect
-	go4.org/unsafe/assume-no-moving-gc v0.0.0-20230525183740-e7c30c78aeb2 // indirect
 	golang.org/x/sys v0.12.0 // indirect
 )
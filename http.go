@@ -0,0 +1,253 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package aliasv6
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxRequestBody is the largest body accepted by the HTTP API.
+const maxRequestBody = 32 << 20
+
+// CheckpointResult is the result of a checkpoint requested with the HTTP
+// API.
+type CheckpointResult struct {
+	// Created is false if the tree did not change since the last
+	// checkpoint.
+	Created bool   `json:"created"`
+	Time    string `json:"time,omitempty"`
+}
+
+// HTTPHandler serves the HTTP API on an Engine:
+//
+//	GET    /lookup/{ip or cidr}     lookup or lookup-prefix
+//	POST   /lookup                  lookup-batch of {"targets": [...]}
+//	PUT    /prefix/{cidr}           insert, with optional {"tags": {...}}
+//	DELETE /prefix/{cidr}           delete, ?recursive=true for a subtree
+//	GET    /prefixes?within={cidr}  subtree
+//	POST   /checkpoint              checkpoint of the tree if it changed
+//	GET    /status                  state of the monitor
+//
+// The responses are those of the commands of the input file.
+type HTTPHandler struct {
+	engine *Engine
+}
+
+// NewHTTPHandler returns an HTTPHandler running the requests on engine.
+func NewHTTPHandler(engine *Engine) *HTTPHandler {
+	return &HTTPHandler{engine: engine}
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == "/lookup":
+		if allowMethod(w, r, http.MethodPost) {
+			h.lookupBatch(w, r)
+		}
+	case strings.HasPrefix(path, "/lookup/"):
+		if allowMethod(w, r, http.MethodGet) {
+			h.lookup(w, strings.TrimPrefix(path, "/lookup/"))
+		}
+	case strings.HasPrefix(path, "/prefix/"):
+		if allowMethod(w, r, http.MethodPut, http.MethodDelete) {
+			h.modify(w, r, strings.TrimPrefix(path, "/prefix/"))
+		}
+	case path == "/prefixes":
+		if allowMethod(w, r, http.MethodGet) {
+			h.prefixes(w, r.URL.Query().Get("within"))
+		}
+	case path == "/checkpoint":
+		if allowMethod(w, r, http.MethodPost) {
+			h.checkpoint(w)
+		}
+	case path == "/status":
+		if allowMethod(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, encodeResult(h.engine.monitor.Snapshot()))
+		}
+	default:
+		writeError(w, http.StatusNotFound, Command{Data: path}, LOOKUP_INVALID_COMMAND, errors.New("unknown endpoint"))
+	}
+}
+
+// allowMethod answers a request whose method is not one of methods with an
+// error response, and returns whether the method is allowed.
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, Command{Data: r.URL.Path}, LOOKUP_INVALID_COMMAND, fmt.Errorf("method %s is not allowed", r.Method))
+	return false
+}
+
+// lookup looks up an address, or the coverage of a prefix.
+func (h *HTTPHandler) lookup(w http.ResponseWriter, target string) {
+	command := Command{Type: "lookup", Data: target}
+	addr, prefix, err := ParseTarget(target)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, command, LOOKUP_PARSE_ERROR, err)
+		return
+	}
+	if prefix.IsValid() {
+		command.Type = "lookup-prefix"
+		command.ParsedData = prefix
+	} else {
+		command.ParsedData = addr
+	}
+	result := h.engine.Run(command)
+	writeJSON(w, http.StatusOK, result)
+	recycleResult(result)
+}
+
+// lookupBatch looks up the targets of the body with a single batch lookup.
+func (h *HTTPHandler) lookupBatch(w http.ResponseWriter, r *http.Request) {
+	command, ok := readCommand(w, r, "lookup-batch")
+	if !ok {
+		return
+	}
+	command.ParsedData = parseBatch(command.Targets)
+	result := h.engine.Run(command)
+	writeJSON(w, http.StatusOK, result)
+	recycleResult(result)
+}
+
+// modify inserts (PUT) or deletes (DELETE) an alias prefix, which is
+// recorded in the journal like the commands of the input file.
+func (h *HTTPHandler) modify(w http.ResponseWriter, r *http.Request, target string) {
+	commandType := "insert"
+	if r.Method == http.MethodDelete {
+		commandType = "delete"
+	}
+	command, ok := readCommand(w, r, commandType)
+	if !ok {
+		return
+	}
+	command.Data = target
+	if recursive := r.URL.Query().Get("recursive"); recursive != "" {
+		var err error
+		if command.Recursive, err = strconv.ParseBool(recursive); err != nil {
+			writeError(w, http.StatusBadRequest, command, LOOKUP_PARSE_ERROR, fmt.Errorf("invalid recursive parameter: %s", recursive))
+			return
+		}
+	}
+	_, prefix, err := ParseTarget(target)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, command, LOOKUP_PARSE_ERROR, err)
+		return
+	}
+	if !prefix.IsValid() {
+		writeError(w, http.StatusBadRequest, command, LOOKUP_INVALID_COMMAND, fmt.Errorf("cannot %s an IP, it should be an IP Network in CIDR notation", commandType))
+		return
+	}
+	command.ParsedData = prefix
+	ack := h.engine.apply(command)
+	writeJSON(w, statusCode(ack.Status), encodeResult(ack))
+}
+
+// prefixes lists the alias prefixes within a prefix.
+func (h *HTTPHandler) prefixes(w http.ResponseWriter, within string) {
+	command := Command{Type: "subtree", Data: within}
+	if within == "" {
+		writeError(w, http.StatusBadRequest, command, LOOKUP_PARSE_ERROR, errors.New("the within parameter should be an IP Network in CIDR notation"))
+		return
+	}
+	addr, prefix, err := ParseTarget(within)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, command, LOOKUP_PARSE_ERROR, err)
+		return
+	}
+	if !prefix.IsValid() {
+		// a single address is looked up as a full length prefix
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	command.ParsedData = prefix
+	writeJSON(w, http.StatusOK, h.engine.Run(command))
+}
+
+// checkpoint creates a checkpoint of the tree if it changed since the last
+// one.
+func (h *HTTPHandler) checkpoint(w http.ResponseWriter) {
+	command := Command{Type: "checkpoint"}
+	checkpointTime, err := h.engine.Checkpoint()
+	if err != nil {
+		log.Errorf("cannot create a checkpoint: %v", err)
+		writeError(w, http.StatusInternalServerError, command, LOOKUP_UNKNOWN_ERROR, err)
+		return
+	}
+	ack := NewAck(command, nil, time.Now())
+	result := CheckpointResult{Created: !checkpointTime.IsZero()}
+	if result.Created {
+		result.Time = checkpointTime.Format(time.RFC3339)
+	}
+	ack.Result = result
+	writeJSON(w, http.StatusOK, encodeResult(ack))
+}
+
+// readCommand decodes the optional JSON body of a request into a command of
+// the given type. It answers an invalid body with an error response.
+func readCommand(w http.ResponseWriter, r *http.Request, commandType string) (Command, bool) {
+	var command Command
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&command)
+	command.ID = compactID(command.ID)
+	command.Type = commandType
+	command.ParsedData = nil
+	if err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, command, LOOKUP_PARSE_ERROR, fmt.Errorf("invalid JSON body: %v", err))
+		return command, false
+	}
+	return command, true
+}
+
+// statusCode returns the HTTP status code of the response to a command
+// with the given status.
+func statusCode(status LookUpStatus) int {
+	switch status {
+	case LOOKUP_SUCCESS:
+		return http.StatusOK
+	case LOOKUP_NO_MATCH:
+		return http.StatusNotFound
+	case LOOKUP_PARSE_ERROR, LOOKUP_INVALID_COMMAND:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError writes the error response to a request, as an Ack of command.
+func writeError(w http.ResponseWriter, code int, command Command, status LookUpStatus, err error) {
+	writeJSON(w, code, encodeResult(NewAck(command, NewLookUpError(status, err), time.Now())))
+}
+
+// writeJSON writes a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, code int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
+	w.Write([]byte{'\n'})
+}
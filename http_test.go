@@ -0,0 +1,177 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package aliasv6
+
+import (
+	"aliasv6/radix"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// httpRequest sends a request to the test server and returns the status
+// code and the decoded body of the response.
+func httpRequest(t *testing.T, server *httptest.Server, method, path, body string) (int, interface{}) {
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if contentType := response.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("%s %s: wrong content type %s", method, path, contentType)
+	}
+	raw, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("%s %s: invalid response %s: %v", method, path, raw, err)
+	}
+	return response.StatusCode, decoded
+}
+
+func TestHTTPHandler(t *testing.T) {
+	mon, stop := testMonitor()
+	defer stop()
+	server := httptest.NewServer(NewHTTPHandler(NewEngine(lookUpTree(), nil, mon)))
+	defer server.Close()
+
+	// The requests run in order on the same tree.
+	for _, tt := range []struct {
+		method string
+		path   string
+		body   string
+		code   int
+		// status is the status of the response, or of each response of a
+		// batch.
+		status []LookUpStatus
+		// field is a field of the response with its expected value.
+		field string
+		value interface{}
+	}{
+		{"GET", "/lookup/2001:db8::1", "", 200, []LookUpStatus{LOOKUP_SUCCESS}, "ip", "2001:db8::1"},
+		{"GET", "/lookup/2001:db9::1", "", 200, []LookUpStatus{LOOKUP_NO_MATCH}, "ip", "2001:db9::1"},
+		{"GET", "/lookup/2001:db8::/48", "", 200, []LookUpStatus{LOOKUP_SUCCESS}, "ip", "2001:db8::/48"},
+		{"GET", "/lookup/2001:db8::%2F48", "", 200, []LookUpStatus{LOOKUP_SUCCESS}, "ip", "2001:db8::/48"},
+		{"GET", "/lookup/not-an-ip", "", 400, []LookUpStatus{LOOKUP_PARSE_ERROR}, "type", "lookup"},
+		{"POST", "/lookup/2001:db8::1", "", 405, []LookUpStatus{LOOKUP_INVALID_COMMAND}, "", nil},
+		{"POST", "/lookup", `{"id":7,"targets":["2001:db8::1","192.0.2.1","2001:db9::1"]}`, 200, []LookUpStatus{LOOKUP_SUCCESS, LOOKUP_SUCCESS, LOOKUP_NO_MATCH}, "", nil},
+		{"POST", "/lookup", `{"targets":["2001:db8::1","bad","2001:db9::1"]}`, 200, []LookUpStatus{LOOKUP_SUCCESS, LOOKUP_PARSE_ERROR, LOOKUP_NO_MATCH}, "", nil},
+		{"POST", "/lookup", `{"targets":`, 400, []LookUpStatus{LOOKUP_PARSE_ERROR}, "type", "lookup-batch"},
+		{"PUT", "/prefix/2001:db9::/48", `{"tags":{"dataset":"http"}}`, 200, []LookUpStatus{LOOKUP_SUCCESS}, "data", "2001:db9::/48"},
+		{"GET", "/lookup/2001:db9::1", "", 200, []LookUpStatus{LOOKUP_SUCCESS}, "ip", "2001:db9::1"},
+		{"PUT", "/prefix/2001:db9::1", "", 400, []LookUpStatus{LOOKUP_INVALID_COMMAND}, "type", "insert"},
+		{"PUT", "/prefix/2001:dba::/48", `{"id":"a","tags":`, 400, []LookUpStatus{LOOKUP_PARSE_ERROR}, "type", "insert"},
+		{"GET", "/prefixes?within=2001:d00::/24", "", 200, []LookUpStatus{LOOKUP_SUCCESS}, "ip", "2001:d00::/24"},
+		{"GET", "/prefixes?within=2001:dba::/32", "", 200, []LookUpStatus{LOOKUP_NO_MATCH}, "", nil},
+		{"GET", "/prefixes", "", 400, []LookUpStatus{LOOKUP_PARSE_ERROR}, "type", "subtree"},
+		{"DELETE", "/prefix/2001:db9::/48", "", 200, []LookUpStatus{LOOKUP_SUCCESS}, "removed", 1.0},
+		{"DELETE", "/prefix/2001:db9::/48", "", 404, []LookUpStatus{LOOKUP_NO_MATCH}, "removed", nil},
+		{"DELETE", "/prefix/2001:db8::/16?recursive=yes", "", 400, []LookUpStatus{LOOKUP_PARSE_ERROR}, "", nil},
+		{"DELETE", "/prefix/2001:db8::/16?recursive=true", "", 200, []LookUpStatus{LOOKUP_SUCCESS}, "removed", 1.0},
+		{"GET", "/lookup/2001:db8::1", "", 200, []LookUpStatus{LOOKUP_NO_MATCH}, "", nil},
+		{"GET", "/unknown", "", 404, []LookUpStatus{LOOKUP_INVALID_COMMAND}, "", nil},
+	} {
+		code, response := httpRequest(t, server, tt.method, tt.path, tt.body)
+		if code != tt.code {
+			t.Errorf("%s %s: wrong code %d, expected %d", tt.method, tt.path, code, tt.code)
+		}
+		responses, ok := response.([]interface{})
+		if !ok {
+			responses = []interface{}{response}
+		}
+		if len(responses) != len(tt.status) {
+			t.Errorf("%s %s: wrong number of responses: %v", tt.method, tt.path, response)
+			continue
+		}
+		for i, r := range responses {
+			fields, _ := r.(map[string]interface{})
+			if fields["status"] != string(tt.status[i]) {
+				t.Errorf("%s %s: wrong status %v, expected %s", tt.method, tt.path, fields["status"], tt.status[i])
+			}
+			if strings.HasPrefix(tt.body, `{"id":7`) && fields["id"] != 7.0 {
+				t.Errorf("%s %s: the id is not echoed: %v", tt.method, tt.path, fields)
+			}
+			if tt.field != "" && fields[tt.field] != tt.value {
+				t.Errorf("%s %s: wrong %s %v, expected %v", tt.method, tt.path, tt.field, fields[tt.field], tt.value)
+			}
+		}
+	}
+
+	code, response := httpRequest(t, server, "GET", "/status", "")
+	state, _ := response.(map[string]interface{})
+	if code != 200 || state["successes"] == nil || state["failures"] == nil {
+		t.Errorf("wrong status: %d %v", code, response)
+	}
+}
+
+func TestHTTPHandlerCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	tree := lookUpTree()
+	tree.SetCheckpointBaseName(filepath.Join(dir, "checkpoint"))
+	journal, err := OpenJournal(filepath.Join(dir, "checkpoint.journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	mon, stop := testMonitor()
+	defer stop()
+	server := httptest.NewServer(NewHTTPHandler(NewEngine(tree, journal, mon)))
+	defer server.Close()
+
+	if code, _ := httpRequest(t, server, "PUT", "/prefix/2001:db9::/48", ""); code != 200 {
+		t.Fatalf("wrong insert code %d", code)
+	}
+	if info, err := os.Stat(journal.Path()); err != nil || info.Size() == 0 {
+		t.Errorf("the insert is not recorded in the journal: %v", err)
+	}
+	for _, created := range []bool{true, false} {
+		code, response := httpRequest(t, server, "POST", "/checkpoint", "")
+		ack, _ := response.(map[string]interface{})
+		result, _ := ack["result"].(map[string]interface{})
+		if code != 200 || ack["status"] != string(LOOKUP_SUCCESS) || result["created"] != created {
+			t.Errorf("wrong checkpoint response: %d %v, expected created %v", code, response, created)
+		}
+	}
+	checkpointFile, _, err := radix.FindLatestCheckpoint(filepath.Join(dir, "checkpoint"))
+	if err != nil || checkpointFile == "" {
+		t.Fatalf("no checkpoint created: %v", err)
+	}
+	restored := radix.InitRadix()
+	if _, err := restored.ImportPrefixFile(checkpointFile, radix.SourceConstruct); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.LookUp(netip.MustParseAddr("2001:db9::1")).Aliased {
+		t.Error("the inserted prefix is not in the checkpoint")
+	}
+	if info, err := os.Stat(journal.Path()); err != nil || info.Size() != 0 {
+		t.Errorf("the checkpointed journal entries are not discarded: %v", err)
+	}
+	if code, _ := httpRequest(t, server, "GET", "/checkpoint", ""); code != 405 {
+		t.Errorf("wrong code %d for a GET of /checkpoint", code)
+	}
+}
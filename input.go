@@ -18,11 +18,11 @@ package aliasv6
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
-	"net"
+	"net/netip"
 	"strings"
 	"time"
 
@@ -30,24 +30,33 @@ import (
 )
 
 type Command struct {
-	Type       string      `json:"type"`
-	Data       string      `json:"data"`
-	ParsedData interface{} `json:"pdata,omitempty"`
+	// ID is an optional id of any JSON type echoed on the responses and
+	// acknowledgements of the command.
+	ID         json.RawMessage   `json:"id,omitempty"`
+	Type       string            `json:"type"`
+	Data       string            `json:"data"`
+	Recursive  bool              `json:"recursive,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Targets    []string          `json:"targets,omitempty"`
+	ParsedData interface{}       `json:"pdata,omitempty"`
+	// Seq is the position of the command in the input with --ordered. An
+	// expanded prefix has one position per address.
+	Seq uint64 `json:"-"`
+	// Err is why the command cannot be run. It is answered with an error
+	// response instead.
+	Err error `json:"-"`
 }
 
-func incrementIP(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
-		}
-	}
-}
-
-func duplicateIP(ip net.IP) net.IP {
-	dup := make(net.IP, len(ip))
-	copy(dup, ip)
-	return dup
+// commandTypes are the types of the commands which can be run.
+var commandTypes = map[string]bool{
+	"lookup":        true,
+	"lookup-batch":  true,
+	"lookup-prefix": true,
+	"covering":      true,
+	"subtree":       true,
+	"insert":        true,
+	"delete":        true,
+	"quit":          true,
 }
 
 // InputTargets is an InputTargetsFunc that calls GetTargets with
@@ -57,18 +66,35 @@ func InputTargets(ch chan<- Command) error {
 }
 
 // GetTargets reads targets from a source, generates LookUpTargets,
-// and delivers them to the provided channel.
+// and delivers them to the provided channel. Commands which cannot be run
+// are delivered with their Err set, so that they are answered with an error
+// response. Only a failure to read the source is returned.
 func GetTargets(source io.Reader, ch chan<- Command) error {
 	reader := bufio.NewReader(source)
-	for {
+	var seq uint64
+	send := func(command Command) {
+		if config.Ordered {
+			command.Seq = seq
+			seq++
+		}
+		ch <- command
+	}
+	reject := func(command Command, status LookUpStatus, err error) {
+		log.Errorf("%s, skipping: %v", status, err)
+		command.ParsedData = nil
+		command.Err = NewLookUpError(status, err)
+		send(command)
+	}
+	for eof := false; !eof; {
 		var target string
 		var err error
 		var command Command
 		message, err := reader.ReadBytes('\n')
 		if err == io.EOF {
+			// the last command may not end with a newline
+			eof = true
 			end := time.Now()
 			log.Infof("no more input is coming: %s", end.Format(time.RFC3339))
-			break
 		} else if err != nil {
 			return err
 		}
@@ -83,54 +109,121 @@ func GetTargets(source io.Reader, ch chan<- Command) error {
 		// 		command.Type = "lookup"
 		// 	}
 		// } else {
+		message = bytes.TrimSpace(message)
+		if len(message) == 0 {
+			continue
+		}
 		err = json.Unmarshal(message, &command)
-		if err != nil {
+		if err != nil && message[0] == '{' {
+			command.ID = compactID(command.ID)
+			if command.Data == "" {
+				// echoes the invalid line
+				command.Data = string(message)
+			}
+			reject(command, LOOKUP_PARSE_ERROR, fmt.Errorf("invalid JSON command: %v", err))
+			continue
+		} else if err != nil {
 			target = string(message)
 			command.Data = target
 			command.Type = "lookup"
 		} else {
 			target = command.Data
+			command.ID = compactID(command.ID)
 		}
 		// }
+		if !commandTypes[command.Type] {
+			reject(command, LOOKUP_INVALID_COMMAND, fmt.Errorf("unknown command type %q", command.Type))
+			continue
+		}
 		if command.Type == "quit" {
 			end := time.Now()
 			log.Infof("quit command has been received; quitting at %s", end.Format(time.RFC3339))
+			// to be acknowledged once the previous commands are done
+			send(command)
 			break
 		}
-		ipnet, err := ParseTarget(target)
+		if command.Type == "lookup-batch" {
+			command.ParsedData = parseBatch(command.Targets)
+			send(command)
+			continue
+		}
+		addr, prefix, err := ParseTarget(target)
 		if err != nil {
-			log.Errorf("parse error, skipping: %v", err)
+			reject(command, LOOKUP_PARSE_ERROR, err)
 			continue
 		}
-		command.ParsedData = ipnet
 		// if command.Type == "insert" {
 		// 	log.Printf("%+v\n", command)
 		// }
-		var ip net.IP
-		if ipnet != nil {
-			if ipnet.Mask != nil {
-				if command.Type == "lookup" {
-					// expand CIDR block into one target for each IP
-					for ip = ipnet.IP.Mask(ipnet.Mask); ipnet.Contains(ip); incrementIP(ip) {
-						command.ParsedData = duplicateIP(ip)
-						ch <- command
-					}
+		if prefix.IsValid() {
+			if command.Type == "lookup" && config.ExpandPrefixes {
+				hostBits := prefix.Addr().BitLen() - prefix.Bits()
+				if hostBits >= 64 || uint64(1)<<hostBits > config.MaxExpansion {
+					reject(command, LOOKUP_INVALID_COMMAND, fmt.Errorf("prefix %s has more than %d addresses to expand", prefix, config.MaxExpansion))
 					continue
 				}
-			} else {
-				if command.Type == "insert" {
-					return errors.New("cannot insert an IP, it should be an IP Network in CIDR notation")
+				// expand CIDR block into one target for each IP
+				for ip := prefix.Addr(); prefix.Contains(ip); ip = ip.Next() {
+					command.ParsedData = ip
+					send(command)
 				}
-				command.ParsedData = ipnet.IP
+				continue
+			}
+			if command.Type == "lookup" {
+				command.Type = "lookup-prefix"
+			}
+			command.ParsedData = prefix
+		} else if command.Type == "lookup-prefix" || command.Type == "covering" || command.Type == "subtree" {
+			// a single address is looked up as a full length prefix
+			command.ParsedData = netip.PrefixFrom(addr, addr.BitLen())
+		} else {
+			if command.Type == "insert" || command.Type == "delete" {
+				reject(command, LOOKUP_INVALID_COMMAND, fmt.Errorf("cannot %s an IP, it should be an IP Network in CIDR notation", command.Type))
+				continue
 			}
+			command.ParsedData = addr
 		}
-		ch <- command
+		send(command)
 	}
 	return nil
 }
 
+// compactID returns the id of a command without insignificant spaces, as it
+// is echoed in the responses.
+func compactID(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, id); err != nil {
+		return id
+	}
+	return buf.Bytes()
+}
+
+// parseBatch returns the addresses of the targets of a lookup-batch command,
+// in the same order. A target which is not an address is the zero Addr, and
+// is answered with a parse error at its position.
+func parseBatch(targets []string) []netip.Addr {
+	addrs := make([]netip.Addr, len(targets))
+	for i, target := range targets {
+		addr, _, err := ParseTarget(target)
+		if err != nil {
+			log.Errorf("parse error in batch target: %v", err)
+			continue
+		}
+		if !addr.IsValid() {
+			log.Errorf("batch target %s is not an address", target)
+			continue
+		}
+		addrs[i] = addr
+	}
+	return addrs
+}
+
 // ParseTarget takes a record from an input file and
-// returns the specified ipnet or an error.
+// returns the specified address or prefix or an error. Only one of addr and
+// prefix is valid, prefix is masked.
 //
 // AliasV6 input files have only one field:
 //
@@ -138,20 +231,22 @@ func GetTargets(source io.Reader, ch chan<- Command) error {
 //
 // Each line specifies a target to perform a lookup by its IP address.
 // A CIDR block may be provided in the IP field, in which case the
+// coverage of the block is looked up. With --expand-prefixes, the
 // framework expands the record into targets for every address in the
-// block.
-func ParseTarget(target string) (ipnet *net.IPNet, err error) {
+// block instead. IPv4 addresses and prefixes are looked up among the IPv4
+// alias prefixes.
+func ParseTarget(target string) (addr netip.Addr, prefix netip.Prefix, err error) {
 	target = strings.TrimSpace(target)
 
 	if target != "" {
-		if ip := net.ParseIP(target); ip != nil {
-			ipnet = &net.IPNet{IP: ip}
-		} else if _, cidr, er := net.ParseCIDR(target); er == nil {
-			ipnet = cidr
+		if ip, er := netip.ParseAddr(target); er == nil && ip.Zone() == "" {
+			addr = ip
+		} else if cidr, er := netip.ParsePrefix(target); er == nil {
+			prefix = cidr.Masked()
 		}
 	}
 
-	if ipnet == nil {
+	if !addr.IsValid() && !prefix.IsValid() {
 		err = fmt.Errorf("record doesn't specify an address or network: %s", target)
 		return
 	}
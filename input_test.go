@@ -0,0 +1,151 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package aliasv6
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readTargets(t *testing.T, input string) []string {
+	ch := make(chan Command, 16)
+	if err := GetTargets(strings.NewReader(input), ch); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	targets := []string{}
+	for command := range ch {
+		if command.Err != nil {
+			targets = append(targets, command.Type+" "+string(TryGetLookUpStatus(command.Err)))
+			continue
+		}
+		targets = append(targets, command.Type+" "+command.ParsedData.(interface{ String() string }).String())
+	}
+	return targets
+}
+
+func TestGetTargetsPrefixLookup(t *testing.T) {
+	defer func(expand bool, max uint64) {
+		config.ExpandPrefixes, config.MaxExpansion = expand, max
+	}(config.ExpandPrefixes, config.MaxExpansion)
+	input := "{\"type\":\"lookup\",\"data\":\"2001:db8::/64\"}\n" +
+		"{\"type\":\"lookup\",\"data\":\"2001:db8::/127\"}\n" +
+		"{\"type\":\"lookup-prefix\",\"data\":\"2001:db8::1\"}\n" +
+		"{\"type\":\"covering\",\"data\":\"2001:db8::/48\"}\n" +
+		"{\"type\":\"subtree\",\"data\":\"2001:db8::2\"}\n" +
+		"{\"type\":\"lookup-prefix\",\"data\":\"192.0.2.1\"}\n"
+	for _, tt := range []struct {
+		name         string
+		expand       bool
+		maxExpansion uint64
+		expected     []string
+	}{
+		{"prefix", false, 0, []string{"lookup-prefix 2001:db8::/64", "lookup-prefix 2001:db8::/127", "lookup-prefix 2001:db8::1/128", "covering 2001:db8::/48", "subtree 2001:db8::2/128", "lookup-prefix 192.0.2.1/32"}},
+		{"expand", true, 2, []string{"lookup invalid-command", "lookup 2001:db8::", "lookup 2001:db8::1", "lookup-prefix 2001:db8::1/128", "covering 2001:db8::/48", "subtree 2001:db8::2/128", "lookup-prefix 192.0.2.1/32"}},
+	} {
+		config.ExpandPrefixes, config.MaxExpansion = tt.expand, tt.maxExpansion
+		targets := readTargets(t, input)
+		if strings.Join(targets, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("%s: wrong targets:\ngiven: %v\nexpected: %v", tt.name, targets, tt.expected)
+		}
+	}
+}
+
+func TestGetTargetsLastLine(t *testing.T) {
+	// The last command is read even without a newline.
+	targets := readTargets(t, "2001:db8::1\n{\"type\":\"lookup\",\"data\":\"2001:db8::2\"}")
+	if expected := []string{"lookup 2001:db8::1", "lookup 2001:db8::2"}; !reflect.DeepEqual(targets, expected) {
+		t.Errorf("wrong targets:\ngiven: %v\nexpected: %v", targets, expected)
+	}
+}
+
+func TestGetTargetsLookUpBatch(t *testing.T) {
+	input := "{\"type\":\"lookup-batch\",\"targets\":[\"2001:db8::1\",\"192.0.2.1\",\"2001:db8::/64\",\"not an ip\",\"2001:db8::2\"]}\n"
+	ch := make(chan Command, 1)
+	if err := GetTargets(strings.NewReader(input), ch); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	command := <-ch
+	// targets which are not addresses keep their position
+	expected := []netip.Addr{netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("192.0.2.1"), {}, {}, netip.MustParseAddr("2001:db8::2")}
+	if addrs, ok := command.ParsedData.([]netip.Addr); command.Type != "lookup-batch" || !ok || !reflect.DeepEqual(addrs, expected) {
+		t.Errorf("wrong batch command: %+v", command)
+	}
+}
+
+func TestGetTargetsID(t *testing.T) {
+	input := "{\"id\":1,\"type\":\"lookup\",\"data\":\"2001:db8::1\"}\n" +
+		"{\"id\": { \"client\" : \"a\" },\"type\":\"insert\",\"data\":\"2001:db8::/32\"}\n" +
+		"{\"type\":\"lookup\",\"data\":\"2001:db8::2\"}\n" +
+		"2001:db8::3\n" +
+		"{\"id\":\"last\",\"type\":\"quit\"}\n" +
+		"{\"id\":2,\"type\":\"lookup\",\"data\":\"2001:db8::4\"}\n"
+	ch := make(chan Command, 16)
+	if err := GetTargets(strings.NewReader(input), ch); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	ids := []string{}
+	for command := range ch {
+		ids = append(ids, command.Type+" "+string(command.ID))
+	}
+	expected := []string{"lookup 1", `insert {"client":"a"}`, "lookup ", "lookup ", `quit "last"`}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("wrong ids:\ngiven: %v\nexpected: %v", ids, expected)
+	}
+}
+
+func TestGetTargetsErrors(t *testing.T) {
+	input := "{\"id\":1,\"type\":\"insert\",\"data\":\"2001:db8::1\"}\n" +
+		"{\"id\":2,\"type\":\"delete\",\"data\":\"2001:db8::1\"}\n" +
+		"{\"id\":3,\"type\":\"lookup\",\"data\":\"not an ip\"}\n" +
+		"{\"id\":4,\"type\":\"insert\",\"data\":\"2001:db8::/32\"\n" +
+		"{\"id\":5,\"type\":\"insert\",\"data\":32}\n" +
+		"{\"id\":6,\"type\":\"lookpu\",\"data\":\"2001:db8::1\"}\n" +
+		"{\"id\":7,\"data\":\"2001:db8::1\"}\n" +
+		"\n" +
+		"   \n" +
+		"2001:db8:::1\n" +
+		"{\"id\":8,\"type\":\"insert\",\"data\":\"2001:db8::/32\"}\n" +
+		"{\"type\":\"quit\"}\n"
+	ch := make(chan Command, 16)
+	if err := GetTargets(strings.NewReader(input), ch); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	responses := []string{}
+	for command := range ch {
+		responses = append(responses, string(command.ID)+" "+command.Type+" "+string(TryGetLookUpStatus(command.Err)))
+	}
+	expected := []string{
+		"1 insert invalid-command",
+		"2 delete invalid-command",
+		"3 lookup parse-error",
+		"  parse-error",
+		"5 insert parse-error",
+		"6 lookpu invalid-command",
+		"7  invalid-command",
+		" lookup parse-error",
+		"8 insert success",
+		" quit success",
+	}
+	if !reflect.DeepEqual(responses, expected) {
+		t.Errorf("wrong responses:\ngiven: %q\nexpected: %q", responses, expected)
+	}
+}
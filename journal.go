@@ -0,0 +1,188 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package aliasv6
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// JournalEntry is a mutating command recorded in the journal.
+type JournalEntry struct {
+	Time      time.Time         `json:"time"`
+	Type      string            `json:"type"`
+	Data      string            `json:"data"`
+	Recursive bool              `json:"recursive,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// Journal is an append-only write-ahead log of the mutating commands
+// (insert, delete) received between two checkpoints. Every command is
+// written and synced to the disk before it is applied to the tree, and the
+// entries persisted by a checkpoint are discarded once it is written. On startup, the
+// entries are replayed on top of the restored checkpoint.
+type Journal struct {
+	mutex sync.Mutex
+	path  string
+	file  *os.File
+}
+
+// OpenJournal opens or creates the journal file at the given path.
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{path: path, file: file}, nil
+}
+
+// Path returns the path of the journal file.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Append records a mutating command in the journal. It returns once the
+// entry is synced to the disk.
+func (j *Journal) Append(command Command, when time.Time) error {
+	raw, err := json.Marshal(JournalEntry{
+		Time:      when,
+		Type:      command.Type,
+		Data:      command.Data,
+		Recursive: command.Recursive,
+		Tags:      command.Tags,
+	})
+	if err != nil {
+		return err
+	}
+	raw = append(raw, '\n')
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if _, err := j.file.Write(raw); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Replay calls apply for every entry in the journal in the order they were
+// recorded and returns the number of replayed entries. An incomplete last
+// entry, left behind by a crash while appending, is discarded.
+func (j *Journal) Replay(apply func(entry JournalEntry) error) (int, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(j.file)
+	counter := 0
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				log.Warnf("discarding incomplete journal entry: %s", line)
+				if err := j.file.Truncate(offset); err != nil {
+					return counter, err
+				}
+				return counter, j.file.Sync()
+			}
+			return counter, nil
+		} else if err != nil {
+			return counter, err
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return counter, fmt.Errorf("corrupted journal entry at offset %d: %w", offset, err)
+		}
+		if err := apply(entry); err != nil {
+			return counter, err
+		}
+		offset += int64(len(line))
+		counter++
+	}
+}
+
+// Truncate removes every entry from the journal. It should be called once
+// the entries are persisted in a checkpoint.
+func (j *Journal) Truncate() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Offset returns the current end of the journal. It marks the entries that
+// are persisted by a checkpoint of the tree taken at the same time.
+func (j *Journal) Offset() (int64, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.file.Seek(0, io.SeekEnd)
+}
+
+// Discard removes the entries recorded before offset, as returned by
+// Offset, once they are persisted in a checkpoint. The entries recorded
+// after offset are kept in a new journal file which replaces the old one
+// atomically.
+func (j *Journal) Discard(offset int64) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if _, err := j.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), "."+filepath.Base(j.path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, j.file)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), j.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	file, err := os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	return nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package aliasv6
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func replayAll(t *testing.T, j *Journal) []JournalEntry {
+	entries := []JournalEntry{}
+	if _, err := j.Replay(func(entry JournalEntry) error {
+		entries = append(entries, entry)
+		return nil
+	}); err != nil {
+		t.Fatalf("cannot replay journal: %v", err)
+	}
+	return entries
+}
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.journal")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	when := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	commands := []Command{
+		{Type: "insert", Data: "2001:db8::/32", Tags: map[string]string{"scan": "1"}},
+		{Type: "delete", Data: "2001:db8::/48", Recursive: true},
+	}
+	for _, command := range commands {
+		if err := j.Append(command, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+
+	// Simulate a crash in the middle of an append.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2024-01-01T00:00:00Z","type":"ins`)
+	f.Close()
+
+	if j, err = OpenJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	expected := []JournalEntry{
+		{Time: when, Type: "insert", Data: "2001:db8::/32", Tags: map[string]string{"scan": "1"}},
+		{Time: when, Type: "delete", Data: "2001:db8::/48", Recursive: true},
+	}
+	if entries := replayAll(t, j); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("wrong journal entries: given: %+v - expected: %+v", entries, expected)
+	}
+	if err := j.Append(Command{Type: "insert", Data: "3001::/16"}, when); err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, JournalEntry{Time: when, Type: "insert", Data: "3001::/16"})
+	if entries := replayAll(t, j); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("incomplete entry should be discarded: given: %+v - expected: %+v", entries, expected)
+	}
+	offset, err := j.Offset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Append(Command{Type: "delete", Data: "3001::/16"}, when); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Discard(offset); err != nil {
+		t.Fatal(err)
+	}
+	expected = []JournalEntry{{Time: when, Type: "delete", Data: "3001::/16"}}
+	if entries := replayAll(t, j); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("entries before the offset should be discarded: given: %+v - expected: %+v", entries, expected)
+	}
+	if err := j.Append(Command{Type: "insert", Data: "4001::/16"}, when); err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, JournalEntry{Time: when, Type: "insert", Data: "4001::/16"})
+	if entries := replayAll(t, j); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("journal should be appendable after discarding: given: %+v - expected: %+v", entries, expected)
+	}
+	if err := j.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	if entries := replayAll(t, j); len(entries) != 0 {
+		t.Errorf("journal should be empty after truncation: %+v", entries)
+	}
+	j.Close()
+}
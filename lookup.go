@@ -18,16 +18,18 @@ package aliasv6
 
 import (
 	"aliasv6/radix"
+	"encoding/json"
 	"errors"
-	"net"
+	"fmt"
+	"net/netip"
 	"time"
-
-	log "github.com/sirupsen/logrus"
-	"inet.af/netaddr"
 )
 
 // LookUpResponse is the result of a lookup on a single ip
 type LookUpResponse struct {
+	// ID is the id of the command, echoed so that clients can match the
+	// responses, which come back in any order, with their commands.
+	ID json.RawMessage `json:"id,omitempty"`
 	// IP and Status are required for all lookups.
 	IP        string       `json:"ip"`
 	Status    LookUpStatus `json:"status"`
@@ -36,8 +38,33 @@ type LookUpResponse struct {
 	Error     string       `json:"error,omitempty"`
 }
 
+// Ack is the response to a command without a lookup result (insert,
+// delete, quit) once it is applied, and to a command which cannot be run,
+// with an error status such as LOOKUP_PARSE_ERROR.
+type Ack struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Type   string          `json:"type"`
+	Data   string          `json:"data,omitempty"`
+	Status LookUpStatus    `json:"status"`
+	// Result is the effect of an insert.
+	Result    interface{} `json:"result,omitempty"`
+	Removed   int         `json:"removed,omitempty"`
+	Timestamp string      `json:"timestamp,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// NewAck returns the acknowledgement of command, with the status detected
+// from err.
+func NewAck(command Command, err error, t time.Time) Ack {
+	ack := Ack{ID: command.ID, Type: command.Type, Data: command.Data, Status: TryGetLookUpStatus(err), Timestamp: t.Format(time.RFC3339)}
+	if err != nil {
+		ack.Error = err.Error()
+	}
+	return ack
+}
+
 // RunLookUp runs a single lookup on a target and returns the resulting data
-func RunLookUp(l *radix.Radix, mon *Monitor, target net.IP, expanded bool) LookUpResponse {
+func RunLookUp(l radix.Store, mon *Monitor, id json.RawMessage, target netip.Addr, expanded bool) LookUpResponse {
 	t := time.Now()
 	label := l.LookUp(target)
 	var status LookUpStatus
@@ -51,17 +78,164 @@ func RunLookUp(l *radix.Radix, mon *Monitor, target net.IP, expanded bool) LookU
 		status = LOOKUP_NO_MATCH
 		err = NewLookUpError(LOOKUP_NO_MATCH, errors.New(label.Metadata)).Err.Error()
 	}
-	var srcIPStr string
-	if expanded {
-		if srcNetAddrIP, ok := netaddr.FromStdIPRaw(target); ok {
-			srcIPStr = srcNetAddrIP.StringExpanded()
-		} else {
-			log.Warnf("cannot expand IP address %s", target)
-			srcIPStr = target.String()
+	resp := LookUpResponse{ID: id, IP: formatIP(target, expanded), Result: label, Error: err, Timestamp: t.Format(time.RFC3339), Status: status}
+	return resp
+}
+
+// RunLookUpPrefix runs a single lookup on a target prefix and returns how
+// much of it is covered by the alias prefixes
+func RunLookUpPrefix(l radix.Store, mon *Monitor, id json.RawMessage, target netip.Prefix, expanded bool) LookUpResponse {
+	t := time.Now()
+	coverage := l.LookUpPrefix(target)
+	var status LookUpStatus
+	var err string
+	switch coverage.Coverage {
+	case radix.CoverageFull:
+		mon.statusesChan <- statusSuccess
+		status = LOOKUP_SUCCESS
+	case radix.CoveragePartial:
+		mon.statusesChan <- statusFailure
+		status = LOOKUP_PARTIAL_MATCH
+		err = NewLookUpError(LOOKUP_PARTIAL_MATCH, errors.New("prefix is partially aliased")).Err.Error()
+	default:
+		mon.statusesChan <- statusFailure
+		status = LOOKUP_NO_MATCH
+		err = NewLookUpError(LOOKUP_NO_MATCH, errors.New("prefix is not aliased")).Err.Error()
+	}
+	prefix := fmt.Sprintf("%s/%d", formatIP(target.Addr(), expanded), target.Bits())
+	return LookUpResponse{ID: id, IP: prefix, Result: coverage, Error: err, Timestamp: t.Format(time.RFC3339), Status: status}
+}
+
+// formatIP returns the string of ip, in the expanded format if requested.
+func formatIP(ip netip.Addr, expanded bool) string {
+	return string(appendIP(make([]byte, 0, 39), ip, expanded))
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendIP appends ip to b, in the expanded format if requested: eight
+// groups of four hexadecimal digits without "::" compression. IPv4 and
+// IPv4-mapped addresses are never expanded.
+func appendIP(b []byte, ip netip.Addr, expanded bool) []byte {
+	if !expanded || ip.Is4() || ip.Is4In6() {
+		return ip.AppendTo(b)
+	}
+	raw := ip.As16()
+	for i, v := range raw {
+		if i > 0 && i%2 == 0 {
+			b = append(b, ':')
 		}
+		b = append(b, hexDigits[v>>4], hexDigits[v&0xF])
+	}
+	return b
+}
+
+// AppendLookUp runs a single lookup on a target like RunLookUp and appends
+// the JSON encoding of the response to b. It does not allocate beyond
+// growing b, so the same buffer can be reused for every lookup.
+func AppendLookUp(b []byte, l radix.Store, mon *Monitor, id json.RawMessage, target netip.Addr, expanded bool) []byte {
+	t := time.Now()
+	match, found := l.LookUpMatch(target)
+	var metadata []byte
+	if found {
+		var raw [64]byte
+		metadata = match.Prefix.AppendTo(raw[:0])
+	}
+	return appendLookUpResponse(b, mon, id, target, expanded, metadata, match.Info, t)
+}
+
+// AppendLookUpBatch looks up the addrs of the targets with a single batch
+// lookup and appends the JSON array of their responses, in the same order, to
+// b. A target whose addr is the zero Addr is answered with a parse error.
+// Each response has the id of the batch.
+func AppendLookUpBatch(b []byte, l radix.Store, mon *Monitor, id json.RawMessage, targets []string, addrs []netip.Addr, expanded bool) []byte {
+	t := time.Now()
+	valid := addrs
+	for _, addr := range addrs {
+		if !addr.IsValid() {
+			valid = make([]netip.Addr, 0, len(addrs))
+			for _, addr := range addrs {
+				if addr.IsValid() {
+					valid = append(valid, addr)
+				}
+			}
+			break
+		}
+	}
+	labels := l.LookUpBatch(valid)
+	b = append(b, '[')
+	var metadata []byte
+	next := 0
+	for i, addr := range addrs {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		if !addr.IsValid() {
+			response := LookUpResponse{ID: id, IP: targets[i], Status: LOOKUP_PARSE_ERROR, Error: fmt.Sprintf("batch target is not an IP address: %s", targets[i]), Timestamp: t.Format(time.RFC3339)}
+			b = append(b, encodeResult(response)...)
+			continue
+		}
+		label := labels[next]
+		next++
+		metadata = metadata[:0]
+		if label.Aliased {
+			metadata = append(metadata, label.Metadata...)
+		}
+		b = appendLookUpResponse(b, mon, id, addr, expanded, metadata, label.Info, t)
+	}
+	return append(b, ']')
+}
+
+// appendLookUpResponse appends the JSON encoding of the LookUpResponse of a
+// lookup on target to b, and reports its status to the monitor. An empty
+// metadata means that target is not aliased.
+func appendLookUpResponse(b []byte, mon *Monitor, id json.RawMessage, target netip.Addr, expanded bool, metadata []byte, info *radix.LeafInfo, t time.Time) []byte {
+	b = append(b, '{')
+	if len(id) > 0 {
+		b = append(b, `"id":`...)
+		b = append(b, id...)
+		b = append(b, ',')
+	}
+	b = append(b, `"ip":"`...)
+	b = appendIP(b, target, expanded)
+	if len(metadata) > 0 {
+		mon.statusesChan <- statusSuccess
+		b = append(b, `","status":"`+LOOKUP_SUCCESS+`","result":{"aliased":true,"metadata":"`...)
+		b = append(b, metadata...)
+		b = append(b, '"')
+		if info != nil {
+			b = append(b, `,"info":`...)
+			b = info.AppendJSON(b)
+		}
+		b = append(b, '}')
 	} else {
-		srcIPStr = target.String()
+		mon.statusesChan <- statusFailure
+		b = append(b, `","status":"`+LOOKUP_NO_MATCH+`","result":{"aliased":false}`...)
 	}
-	resp := LookUpResponse{IP: srcIPStr, Result: label, Error: err, Timestamp: t.Format(time.RFC3339), Status: status}
-	return resp
+	b = append(b, `,"timestamp":"`...)
+	b = t.AppendFormat(b, time.RFC3339)
+	return append(b, `"}`...)
+}
+
+// RunPrefixQuery runs a covering or subtree query on a target prefix and
+// returns the matching alias prefixes
+func RunPrefixQuery(l radix.Store, mon *Monitor, id json.RawMessage, queryType string, target netip.Prefix, expanded bool) LookUpResponse {
+	t := time.Now()
+	var labels []radix.Label
+	if queryType == "covering" {
+		labels = l.Covering(target)
+	} else {
+		labels = l.CoveredBy(target)
+	}
+	status := LOOKUP_SUCCESS
+	err := ""
+	if len(labels) > 0 {
+		mon.statusesChan <- statusSuccess
+	} else {
+		mon.statusesChan <- statusFailure
+		status = LOOKUP_NO_MATCH
+		err = NewLookUpError(LOOKUP_NO_MATCH, errors.New("no matching alias prefix")).Err.Error()
+	}
+	prefix := fmt.Sprintf("%s/%d", formatIP(target.Addr(), expanded), target.Bits())
+	return LookUpResponse{ID: id, IP: prefix, Result: labels, Error: err, Timestamp: t.Format(time.RFC3339), Status: status}
 }
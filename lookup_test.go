@@ -0,0 +1,192 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package aliasv6
+
+import (
+	"aliasv6/radix"
+	"encoding/json"
+	"net/netip"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lookUpTree returns a tree with an IPv6 alias prefix with tags which need
+// escaping and an IPv4 alias prefix.
+func lookUpTree() *radix.Radix {
+	tree := radix.InitRadix()
+	seen := time.Date(2024, 1, 1, 0, 0, 0, 5, time.UTC)
+	tags := map[string]string{"dataset": "<6sense> & \"friends\"\n", "vantage": "us ", "broken": "\xff"}
+	tree.InsertWithInfo(netip.MustParsePrefix("2001:db8::/32"), radix.NewLeafInfo(radix.SourceConstruct, seen, tags))
+	tree.InsertWithInfo(netip.MustParsePrefix("192.0.2.0/24"), radix.NewLeafInfo(radix.SourceInsert, seen, nil))
+	return tree
+}
+
+// testMonitor returns a monitor and a function which stops it.
+func testMonitor() (*Monitor, func()) {
+	var wg sync.WaitGroup
+	mon := MakeMonitor(1, &wg)
+	return mon, func() {
+		mon.Stop()
+		wg.Wait()
+	}
+}
+
+var timestampField = regexp.MustCompile(`"timestamp":"[^"]*"`)
+
+func TestAppendLookUp(t *testing.T) {
+	tree := lookUpTree()
+	mon, stop := testMonitor()
+	defer stop()
+	for _, tt := range []struct {
+		ip       string
+		expanded bool
+		id       string
+	}{
+		{"2001:db8::1", false, ""},
+		{"2001:db8::1", true, ""},
+		{"2001:db9::1", false, ""},
+		{"2001:db9::1", true, ""},
+		{"192.0.2.1", true, ""},
+		{"::ffff:192.0.2.1", true, ""},
+		{"198.51.100.1", false, ""},
+		{"2001:db8::1", false, "42"},
+		{"2001:db9::1", false, `"req-\"1\""`},
+		{"192.0.2.1", false, `{"client":"a","seq":[1,2]}`},
+	} {
+		target := netip.MustParseAddr(tt.ip)
+		var id json.RawMessage
+		if tt.id != "" {
+			id = json.RawMessage(tt.id)
+		}
+		expected, err := json.Marshal(RunLookUp(tree, mon, id, target, tt.expanded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		given := AppendLookUp([]byte("previous result"), tree, mon, id, target, tt.expanded)[len("previous result"):]
+		if !json.Valid(given) {
+			t.Errorf("%s: invalid JSON: %s", tt.ip, given)
+		}
+		// The timestamps may be a second apart.
+		given = timestampField.ReplaceAll(given, []byte(`"timestamp":""`))
+		expected = timestampField.ReplaceAll(expected, []byte(`"timestamp":""`))
+		if string(given) != string(expected) {
+			t.Errorf("%s: wrong encoding:\ngiven:    %s\nexpected: %s", tt.ip, given, expected)
+		}
+	}
+}
+
+func TestAppendLookUpBatch(t *testing.T) {
+	tree := lookUpTree()
+	mon, stop := testMonitor()
+	defer stop()
+	ips := []string{"2001:db8::1", "2001:db8::2", "2001:db9::1", "192.0.2.1", "198.51.100.1"}
+	targets := []netip.Addr{}
+	responses := []LookUpResponse{}
+	for _, ip := range ips {
+		target := netip.MustParseAddr(ip)
+		targets = append(targets, target)
+		responses = append(responses, RunLookUp(tree, mon, nil, target, true))
+	}
+	withID := []LookUpResponse{}
+	for _, response := range responses {
+		response.ID = json.RawMessage(`"batch"`)
+		withID = append(withID, response)
+	}
+	// A bad target in the middle is answered at its position.
+	invalid := []string{"2001:db8::1", "not an ip", "2001:db9::1", "2001:db8::/64"}
+	invalidAddrs := []netip.Addr{targets[0], {}, targets[2], {}}
+	invalidResponses := []LookUpResponse{
+		responses[0],
+		{IP: "not an ip", Status: LOOKUP_PARSE_ERROR, Error: "batch target is not an IP address: not an ip", Timestamp: "-"},
+		responses[2],
+		{IP: "2001:db8::/64", Status: LOOKUP_PARSE_ERROR, Error: "batch target is not an IP address: 2001:db8::/64", Timestamp: "-"},
+	}
+	for _, tt := range []struct {
+		targets   []string
+		addrs     []netip.Addr
+		id        json.RawMessage
+		responses []LookUpResponse
+	}{
+		{ips, targets, nil, responses},
+		{ips, targets, json.RawMessage(`"batch"`), withID},
+		{[]string{}, []netip.Addr{}, nil, []LookUpResponse{}},
+		{invalid, invalidAddrs, nil, invalidResponses},
+	} {
+		expected, err := json.Marshal(tt.responses)
+		if err != nil {
+			t.Fatal(err)
+		}
+		given := AppendLookUpBatch(nil, tree, mon, tt.id, tt.targets, tt.addrs, true)
+		given = timestampField.ReplaceAll(given, []byte(`"timestamp":""`))
+		expected = timestampField.ReplaceAll(expected, []byte(`"timestamp":""`))
+		if string(given) != string(expected) {
+			t.Errorf("wrong batch encoding:\ngiven:    %s\nexpected: %s", given, expected)
+		}
+	}
+}
+
+func TestAppendLookUpAllocs(t *testing.T) {
+	tree := lookUpTree()
+	mon, stop := testMonitor()
+	defer stop()
+	for _, ip := range []string{"2001:db9::1", "2001:db8::1", "192.0.2.1"} {
+		target := netip.MustParseAddr(ip)
+		b := make([]byte, 0, 1024)
+		allocs := testing.AllocsPerRun(1000, func() {
+			b = AppendLookUp(b[:0], tree, mon, json.RawMessage("17"), target, true)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: %.1f allocations per lookup, expected none", ip, allocs)
+		}
+	}
+}
+
+func BenchmarkAppendLookUp(b *testing.B) {
+	tree := lookUpTree()
+	mon, stop := testMonitor()
+	defer stop()
+	for _, bb := range []struct {
+		name string
+		ip   string
+	}{
+		{"match", "2001:db8::1"},
+		{"no-match", "2001:db9::1"},
+	} {
+		target := netip.MustParseAddr(bb.ip)
+		b.Run(bb.name, func(b *testing.B) {
+			buf := make([]byte, 0, 1024)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf = AppendLookUp(buf[:0], tree, mon, nil, target, false)
+			}
+		})
+	}
+}
+
+func BenchmarkRunLookUp(b *testing.B) {
+	tree := lookUpTree()
+	mon, stop := testMonitor()
+	defer stop()
+	target := netip.MustParseAddr("2001:db9::1")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := json.Marshal(RunLookUp(tree, mon, nil, target, false)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
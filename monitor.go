@@ -18,6 +18,7 @@ package aliasv6
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -26,10 +27,15 @@ import (
 // Monitor is a collection of states per lookup and a channel to communicate
 // those lookups to the monitor
 type Monitor struct {
-	state        *State
+	state *State
+	// mutex guards state, which is read while the lookups are counted.
+	mutex        sync.Mutex
 	statusesChan chan status
 	// Callback is invoked after each lookup.
 	Callback func(string)
+	// reorderDepth is the number of results held by the Reorderer.
+	reorderDepth    atomic.Int64
+	maxReorderDepth atomic.Int64
 }
 
 // State contains the respective number of successes and failures
@@ -37,6 +43,9 @@ type Monitor struct {
 type State struct {
 	Successes uint `json:"successes"`
 	Failures  uint `json:"failures"`
+	// MaxReorderDepth is the largest number of results held at once to be
+	// written in order (--ordered).
+	MaxReorderDepth uint `json:"max_reorder_depth,omitempty"`
 }
 
 type status uint
@@ -52,10 +61,30 @@ func (m *Monitor) GetStatus() *State {
 	return m.state
 }
 
+// Snapshot returns a copy of the current state, which can be read while
+// the lookups go on.
+func (m *Monitor) Snapshot() State {
+	m.mutex.Lock()
+	state := *m.state
+	m.mutex.Unlock()
+	state.MaxReorderDepth = uint(m.maxReorderDepth.Load())
+	return state
+}
+
 func (m *Monitor) GetStatusChan() chan status {
 	return m.statusesChan
 }
 
+// setReorderDepth records the number of results held by the Reorderer.
+func (m *Monitor) setReorderDepth(depth int) {
+	m.reorderDepth.Store(int64(depth))
+	for max := m.maxReorderDepth.Load(); int64(depth) > max; max = m.maxReorderDepth.Load() {
+		if m.maxReorderDepth.CompareAndSwap(max, int64(depth)) {
+			break
+		}
+	}
+}
+
 // Stop indicates the monitor is done and the internal channel should be closed.
 // This function does not block, but will allow a call to Wait() on the
 // WaitGroup passed to MakeMonitor to return.
@@ -86,9 +115,14 @@ func MakeMonitor(statusChanSize int, wg *sync.WaitGroup) *Monitor {
 			case <-ticker.C:
 				tickerCount++
 				ticker.Stop()
+				m.mutex.Lock()
 				success := m.state.Successes
 				failure := m.state.Failures
+				m.mutex.Unlock()
 				log.Infof("Total Processed: %d (%.2f IPs/sec; +m: %d) -> Aliased: %d; No-match: %d", success+failure, float64(success+failure)/float64(tickerCount), (success+failure)-lastTotal, success, failure)
+				if max := m.maxReorderDepth.Load(); max > 0 {
+					log.Infof("Reorder buffer: %d results (max %d)", m.reorderDepth.Load(), max)
+				}
 				ticker.Reset(time.Duration(time.Second))
 				lastTotal = success + failure
 			case <-quitTimerChannel:
@@ -102,15 +136,18 @@ func MakeMonitor(statusChanSize int, wg *sync.WaitGroup) *Monitor {
 		defer wg.Done()
 		timerReady.Wait()
 		for s := range m.statusesChan {
+			m.mutex.Lock()
 			switch s {
 			case statusSuccess:
 				m.state.Successes++
 			case statusFailure:
 				m.state.Failures++
-			default:
-				continue
 			}
+			m.mutex.Unlock()
 		}
+		m.mutex.Lock()
+		m.state.MaxReorderDepth = uint(m.maxReorderDepth.Load())
+		m.mutex.Unlock()
 		quitTimerChannel <- struct{}{}
 		timerWorkerDone.Wait()
 	}()
@@ -19,8 +19,93 @@ package aliasv6
 import (
 	"bufio"
 	"io"
+	"sync"
 )
 
+// maxRecycledResult is the capacity above which result buffers are left to
+// the garbage collector instead of being recycled.
+const maxRecycledResult = 4096
+
+// resultBuffers recycles the buffers results are encoded into once they are
+// written by OutputResults.
+var resultBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 256)
+		return &b
+	},
+}
+
+// GetResultBuffer returns an empty buffer to encode a result into, e.g. with
+// AppendLookUp. It is recycled once the result is written by OutputResults.
+func GetResultBuffer() []byte {
+	return (*resultBuffers.Get().(*[]byte))[:0]
+}
+
+// recycleResult makes the buffer of a written result available to
+// GetResultBuffer.
+func recycleResult(result []byte) {
+	if cap(result) > maxRecycledResult {
+		return
+	}
+	b := result[:0]
+	resultBuffers.Put(&b)
+}
+
+// Reorderer forwards results in the order of the sequence numbers of their
+// commands (--ordered). A result which is ready before the results of the
+// earlier commands is held until they are forwarded. At most window results
+// are held: the result of a command window commands after the oldest
+// pending one waits until it is forwarded.
+type Reorderer struct {
+	mutex   sync.Mutex
+	ready   *sync.Cond
+	next    uint64
+	pending []pendingResult
+	depth   int
+	out     chan<- []byte
+	mon     *Monitor
+}
+
+// pendingResult is a slot of the reorder buffer.
+type pendingResult struct {
+	done   bool
+	result []byte
+}
+
+// NewReorderer returns a Reorderer forwarding results to out, which reports
+// the depth of its buffer to mon.
+func NewReorderer(window int, out chan<- []byte, mon *Monitor) *Reorderer {
+	r := &Reorderer{pending: make([]pendingResult, window), out: out, mon: mon}
+	r.ready = sync.NewCond(&r.mutex)
+	return r
+}
+
+// Put adds the result of the command with the given sequence number. A nil
+// result means that the command has no output. Every sequence number must be
+// put exactly once.
+func (r *Reorderer) Put(seq uint64, result []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	window := uint64(len(r.pending))
+	for seq >= r.next+window {
+		r.ready.Wait()
+	}
+	r.pending[seq%window] = pendingResult{done: true, result: result}
+	r.depth++
+	for slot := &r.pending[r.next%window]; slot.done; slot = &r.pending[r.next%window] {
+		if slot.result != nil {
+			r.out <- slot.result
+		}
+		*slot = pendingResult{}
+		r.depth--
+		r.next++
+	}
+	if r.mon != nil {
+		r.mon.setReorderDepth(r.depth)
+	}
+	r.ready.Broadcast()
+}
+
 // OutputResultsWriterFunc returns an OutputResultsFunc that wraps an io.Writer
 // in a buffered writer, and uses OutputResults.
 func OutputResultsWriterFunc(w io.Writer) OutputResultsFunc {
@@ -34,10 +119,7 @@ func OutputResultsWriterFunc(w io.Writer) OutputResultsFunc {
 // OutputResults writes results to a buffered Writer from a channel.
 func OutputResults(w *bufio.Writer, results <-chan []byte) error {
 	for result := range results {
-		if _, err := w.Write(result); err != nil {
-			return err
-		}
-		if err := w.WriteByte('\n'); err != nil {
+		if err := writeResult(w, result); err != nil {
 			return err
 		}
 		if config.Flush {
@@ -47,6 +129,42 @@ func OutputResults(w *bufio.Writer, results <-chan []byte) error {
 	return nil
 }
 
+// OutputResultsSessionFunc returns an OutputResultsFunc writing the
+// responses of a session to its connection. Since a client waits for the
+// responses to the commands it sent, they are flushed whenever no further
+// result is ready. After a write error, the remaining results are discarded.
+func OutputResultsSessionFunc(w io.Writer) OutputResultsFunc {
+	return func(results <-chan []byte) error {
+		buf := bufio.NewWriter(w)
+		var err error
+		for result := range results {
+			if err != nil {
+				recycleResult(result)
+				continue
+			}
+			if err = writeResult(buf, result); err == nil && len(results) == 0 {
+				err = buf.Flush()
+			}
+		}
+		if err != nil {
+			return err
+		}
+		return buf.Flush()
+	}
+}
+
+// writeResult writes a result and a newline to w, and recycles its buffer.
+func writeResult(w *bufio.Writer, result []byte) error {
+	if _, err := w.Write(result); err != nil {
+		return err
+	}
+	if err := w.WriteByte('\n'); err != nil {
+		return err
+	}
+	recycleResult(result)
+	return nil
+}
+
 // OutputResultsFunc is a function type for result output functions.
 //
 // A function of this type receives results on the provided channel
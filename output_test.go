@@ -0,0 +1,89 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package aliasv6
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReorderer(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		window  int
+		workers int
+		results int
+	}{
+		{"single worker", 1, 1, 100},
+		{"window of one", 1, 8, 1000},
+		{"small window", 4, 16, 10000},
+		{"large window", 1024, 64, 10000},
+	} {
+		mon, stop := testMonitor()
+		out := make(chan []byte, 16)
+		r := NewReorderer(tt.window, out, mon)
+		seqs := make(chan uint64)
+		var workers sync.WaitGroup
+		for i := 0; i < tt.workers; i++ {
+			workers.Add(1)
+			go func(rng *rand.Rand) {
+				defer workers.Done()
+				for seq := range seqs {
+					// delay some results so that they are put out of order
+					if rng.Intn(8) == 0 {
+						time.Sleep(time.Duration(rng.Intn(50)) * time.Microsecond)
+					}
+					// every third command has no output
+					if seq%3 == 2 {
+						r.Put(seq, nil)
+					} else {
+						r.Put(seq, []byte(strconv.FormatUint(seq, 10)))
+					}
+				}
+			}(rand.New(rand.NewSource(int64(i))))
+		}
+		go func() {
+			for seq := 0; seq < tt.results; seq++ {
+				seqs <- uint64(seq)
+			}
+			close(seqs)
+			workers.Wait()
+			close(out)
+		}()
+		expected := uint64(0)
+		forwarded := 0
+		for result := range out {
+			if expected%3 == 2 {
+				expected++
+			}
+			if string(result) != strconv.FormatUint(expected, 10) {
+				t.Fatalf("%s: wrong order: given %s, expected %d", tt.name, result, expected)
+			}
+			expected++
+			forwarded++
+		}
+		if outputs := tt.results - tt.results/3; forwarded != outputs {
+			t.Errorf("%s: %d results forwarded, expected %d", tt.name, forwarded, outputs)
+		}
+		stop()
+		if depth := mon.GetStatus().MaxReorderDepth; depth > uint(tt.window) {
+			t.Errorf("%s: reorder depth %d larger than the window %d", tt.name, depth, tt.window)
+		}
+	}
+}
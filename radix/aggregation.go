@@ -0,0 +1,98 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package radix
+
+import (
+	"net/netip"
+)
+
+// AggregationPolicy decides whether a parent prefix can be inferred as
+// aliased once a new alias prefix is added to a store. The store keeps asking
+// the policy with every inferred parent until it returns an invalid prefix,
+// so inferences cascade upwards.
+type AggregationPolicy interface {
+	// Aggregate returns the parent prefix of prefix which should be marked
+	// as aliased, or the zero Prefix if nothing can be inferred. The
+	// returned prefix must be shorter than prefix. The given prefix is
+	// always a 128-bit prefix, IPv4 prefixes are mapped into ::ffff:0:0/96.
+	Aggregate(s Store, prefix netip.Prefix) netip.Prefix
+}
+
+// NoAggregationPolicy never infers a parent prefix, so a tree keeps exactly
+// the alias prefixes inserted into it, e.g. those of a checkpoint written
+// with another policy.
+type NoAggregationPolicy struct{}
+
+// Aggregate is an implementation of AggregationPolicy.
+func (p *NoAggregationPolicy) Aggregate(s Store, prefix netip.Prefix) netip.Prefix {
+	return netip.Prefix{}
+}
+
+// SiblingPolicy marks the parent prefix as aliased if both of its halves are
+// aliased (e.g. two /40 prefixes differing at the 39th bit -> /39 alias
+// prefix). Parents shorter than MinLength are never inferred.
+type SiblingPolicy struct {
+	MinLength int
+}
+
+// Aggregate is an implementation of AggregationPolicy.
+func (p *SiblingPolicy) Aggregate(s Store, prefix netip.Prefix) netip.Prefix {
+	ones := prefix.Bits()
+	if ones == 0 || ones-1 < p.MinLength {
+		return netip.Prefix{}
+	}
+	sibling := keyOf(prefix.Addr()).flip(ones - 1)
+	if !s.IsAliased(netip.PrefixFrom(sibling.addr(), ones)) {
+		return netip.Prefix{}
+	}
+	parent, _ := prefix.Addr().Prefix(ones - 1)
+	return parent
+}
+
+// NibblePolicy marks a prefix /N as aliased if at least Threshold of its 16
+// /N+4 sub-prefixes are aliased, which follows the nibble based dealiasing of
+// 6Sense. Only nibble aligned prefixes are aggregated, and parents shorter
+// than MinLength are never inferred.
+type NibblePolicy struct {
+	Threshold int
+	MinLength int
+}
+
+// Aggregate is an implementation of AggregationPolicy.
+func (p *NibblePolicy) Aggregate(s Store, prefix netip.Prefix) netip.Prefix {
+	ones := prefix.Bits()
+	if ones < 4 || ones%4 != 0 || ones-4 < p.MinLength {
+		return netip.Prefix{}
+	}
+	parent, _ := prefix.Addr().Prefix(ones - 4)
+	base := keyOf(parent.Addr())
+	aliased := 0
+	for nibble := 0; nibble < 16; nibble++ {
+		sub := base
+		for b := 0; b < 4; b++ {
+			if nibble&(8>>b) != 0 {
+				sub = sub.flip(ones - 4 + b)
+			}
+		}
+		if s.IsAliased(netip.PrefixFrom(sub.addr(), ones)) {
+			aliased++
+		}
+	}
+	if aliased < p.Threshold {
+		return netip.Prefix{}
+	}
+	return parent
+}
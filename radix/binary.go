@@ -0,0 +1,470 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// Checkpoint formats
+const (
+	FormatText   = "text"
+	FormatBinary = "binary"
+)
+
+// Binary checkpoint layout (all integers are big-endian):
+//
+//	header:  magic (4 bytes) | version (uint16) | creation time (int64, unix nanoseconds) |
+//	         prefix count (uint64) | tool version length (uint16) | tool version
+//	records: address (16 bytes) | prefix length (uint8) | has attributes (uint8) | attributes
+//	trailer: SHA-256 of everything above (32 bytes)
+//
+// Attributes are encoded as: source (string32) | first seen (time) | last confirmed (time) |
+// tag count (uint32) | tags (string32 key, string32 value) | merged from count (uint32) |
+// merged from prefixes (string32), where string32 is a string prefixed with its uint32
+// length, and time is the unix seconds (int64) followed by the nanoseconds (uint32).
+const (
+	binaryMagic   = "AV6C"
+	binaryVersion = uint16(1)
+	// maxPreallocated bounds the memory allocated from a count or a length
+	// read from a checkpoint, which is not verified until the checksum is.
+	maxPreallocated = 1 << 16
+)
+
+// ToolVersion is recorded in the header of binary checkpoints. It can be set
+// at build time with -ldflags "-X aliasv6/radix.ToolVersion=<version>".
+var ToolVersion = "aliasv6-dev"
+
+// ErrChecksumMismatch is returned when a binary checkpoint is incomplete or corrupted.
+var ErrChecksumMismatch = errors.New("binary checkpoint checksum mismatch")
+
+// BinaryCheckpointHeader is the header of a binary checkpoint.
+type BinaryCheckpointHeader struct {
+	Version     uint16
+	CreatedAt   time.Time
+	Count       uint64
+	ToolVersion string
+}
+
+// binaryWriter writes big-endian values and keeps the first error.
+type binaryWriter struct {
+	w   io.Writer
+	err error
+	buf [8]byte
+}
+
+func (bw *binaryWriter) write(p []byte) {
+	if bw.err == nil {
+		_, bw.err = bw.w.Write(p)
+	}
+}
+
+func (bw *binaryWriter) uint8(v uint8) {
+	bw.buf[0] = v
+	bw.write(bw.buf[:1])
+}
+
+func (bw *binaryWriter) uint16(v uint16) {
+	binary.BigEndian.PutUint16(bw.buf[:2], v)
+	bw.write(bw.buf[:2])
+}
+
+func (bw *binaryWriter) uint32(v uint32) {
+	binary.BigEndian.PutUint32(bw.buf[:4], v)
+	bw.write(bw.buf[:4])
+}
+
+func (bw *binaryWriter) uint64(v uint64) {
+	binary.BigEndian.PutUint64(bw.buf[:8], v)
+	bw.write(bw.buf[:8])
+}
+
+// count writes a uint32 count or length. A larger one fails the checkpoint
+// rather than being written so that it cannot be read back.
+func (bw *binaryWriter) count(n int, what string) {
+	if bw.err == nil && uint64(n) > math.MaxUint32 {
+		bw.err = fmt.Errorf("%s %d does not fit in a binary checkpoint", what, n)
+	}
+	bw.uint32(uint32(n))
+}
+
+func (bw *binaryWriter) string16(s string) {
+	if bw.err == nil && len(s) > math.MaxUint16 {
+		bw.err = fmt.Errorf("string of %d bytes does not fit in a binary checkpoint header", len(s))
+	}
+	bw.uint16(uint16(len(s)))
+	bw.write([]byte(s))
+}
+
+func (bw *binaryWriter) string32(s string) {
+	bw.count(len(s), "string length")
+	bw.write([]byte(s))
+}
+
+func (bw *binaryWriter) time(t time.Time) {
+	bw.uint64(uint64(t.Unix()))
+	bw.uint32(uint32(t.Nanosecond()))
+}
+
+func (bw *binaryWriter) info(info *LeafInfo) {
+	if info == nil {
+		bw.uint8(0)
+		return
+	}
+	bw.uint8(1)
+	bw.string32(info.Source)
+	bw.time(info.FirstSeen)
+	bw.time(info.LastConfirmed)
+	keys := make([]string, 0, len(info.Tags))
+	for k := range info.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	bw.count(len(keys), "tag count")
+	for _, k := range keys {
+		bw.string32(k)
+		bw.string32(info.Tags[k])
+	}
+	bw.count(len(info.MergedFrom), "merged from count")
+	for _, prefix := range info.MergedFrom {
+		bw.string32(prefix)
+	}
+}
+
+// writeBinary writes count alias prefixes, visited by walk, in the binary
+// checkpoint format.
+func writeBinary(w io.Writer, createdAt time.Time, count int, walk func(fn func(prefix netip.Prefix, info *LeafInfo) bool)) error {
+	checksum := sha256.New()
+	bw := &binaryWriter{w: io.MultiWriter(w, checksum)}
+	bw.write([]byte(binaryMagic))
+	bw.uint16(binaryVersion)
+	bw.uint64(uint64(createdAt.UnixNano()))
+	bw.uint64(uint64(count))
+	bw.string16(ToolVersion)
+	walk(func(prefix netip.Prefix, info *LeafInfo) bool {
+		prefix = normalizePrefix(prefix)
+		raw := prefix.Addr().As16()
+		bw.write(raw[:])
+		bw.uint8(uint8(prefix.Bits()))
+		bw.info(info)
+		return bw.err == nil
+	})
+	if bw.err != nil {
+		return bw.err
+	}
+	_, err := w.Write(checksum.Sum(nil))
+	return err
+}
+
+// binaryReader reads big-endian values, feeds them to the checksum and keeps
+// the first error.
+type binaryReader struct {
+	r        io.Reader
+	checksum hash.Hash
+	err      error
+	buf      [8]byte
+}
+
+func (br *binaryReader) read(p []byte) {
+	if br.err != nil {
+		return
+	}
+	if _, br.err = io.ReadFull(br.r, p); br.err == nil {
+		br.checksum.Write(p)
+	} else if br.err == io.EOF {
+		br.err = io.ErrUnexpectedEOF
+	}
+}
+
+func (br *binaryReader) uint8() uint8 {
+	br.read(br.buf[:1])
+	return br.buf[0]
+}
+
+func (br *binaryReader) uint16() uint16 {
+	br.read(br.buf[:2])
+	return binary.BigEndian.Uint16(br.buf[:2])
+}
+
+func (br *binaryReader) uint32() uint32 {
+	br.read(br.buf[:4])
+	return binary.BigEndian.Uint32(br.buf[:4])
+}
+
+func (br *binaryReader) uint64() uint64 {
+	br.read(br.buf[:8])
+	return binary.BigEndian.Uint64(br.buf[:8])
+}
+
+func (br *binaryReader) string16() string {
+	p := make([]byte, br.uint16())
+	br.read(p)
+	return string(p)
+}
+
+// string32 reads a string prefixed with its uint32 length. A long string is
+// read in chunks of maxPreallocated bytes, so that a corrupted length cannot
+// allocate more than the checkpoint holds.
+func (br *binaryReader) string32() string {
+	n := uint64(br.uint32())
+	if br.err != nil {
+		return ""
+	}
+	p := make([]byte, preallocated(n))
+	br.read(p)
+	for uint64(len(p)) < n && br.err == nil {
+		start := len(p)
+		p = append(p, make([]byte, preallocated(n-uint64(start)))...)
+		br.read(p[start:])
+	}
+	return string(p)
+}
+
+func (br *binaryReader) time() time.Time {
+	sec := int64(br.uint64())
+	nsec := int64(br.uint32())
+	return time.Unix(sec, nsec).UTC()
+}
+
+func (br *binaryReader) info() *LeafInfo {
+	if br.uint8() == 0 {
+		return nil
+	}
+	info := &LeafInfo{
+		Source:        br.string32(),
+		FirstSeen:     br.time(),
+		LastConfirmed: br.time(),
+	}
+	if numTags := uint64(br.uint32()); numTags > 0 && br.err == nil {
+		info.Tags = make(map[string]string, preallocated(numTags))
+		for i := uint64(0); i < numTags && br.err == nil; i++ {
+			k := br.string32()
+			info.Tags[k] = br.string32()
+		}
+	}
+	if numMerged := uint64(br.uint32()); numMerged > 0 && br.err == nil {
+		info.MergedFrom = make([]string, 0, preallocated(numMerged))
+		for i := uint64(0); i < numMerged && br.err == nil; i++ {
+			info.MergedFrom = append(info.MergedFrom, br.string32())
+		}
+	}
+	return info
+}
+
+func (br *binaryReader) header() BinaryCheckpointHeader {
+	magic := make([]byte, len(binaryMagic))
+	br.read(magic)
+	if br.err == nil && string(magic) != binaryMagic {
+		br.err = errors.New("not a binary checkpoint")
+	}
+	header := BinaryCheckpointHeader{Version: br.uint16()}
+	if br.err == nil && header.Version != binaryVersion {
+		br.err = fmt.Errorf("unsupported binary checkpoint version %d", header.Version)
+	}
+	header.CreatedAt = time.Unix(0, int64(br.uint64())).UTC()
+	header.Count = br.uint64()
+	header.ToolVersion = br.string16()
+	return header
+}
+
+// preallocated returns the capacity to allocate for count values read from
+// a checkpoint. A corrupted count cannot allocate more than maxPreallocated
+// values before the checkpoint is found to be truncated.
+func preallocated(count uint64) int {
+	if count > maxPreallocated {
+		return maxPreallocated
+	}
+	return int(count)
+}
+
+// binaryRecord is an alias prefix read from a binary checkpoint.
+type binaryRecord struct {
+	prefix netip.Prefix
+	info   *LeafInfo
+}
+
+// readBinary reads a binary checkpoint. The records are only returned once
+// the checksum is verified.
+func readBinary(r io.Reader) (BinaryCheckpointHeader, []binaryRecord, error) {
+	br := &binaryReader{r: r, checksum: sha256.New()}
+	header := br.header()
+	if br.err != nil {
+		return header, nil, br.err
+	}
+	records := make([]binaryRecord, 0, preallocated(header.Count))
+	for i := uint64(0); i < header.Count && br.err == nil; i++ {
+		var raw [16]byte
+		br.read(raw[:])
+		ones := int(br.uint8())
+		if br.err == nil && ones > 128 {
+			br.err = fmt.Errorf("invalid prefix length %d in record %d", ones, i)
+		}
+		info := br.info()
+		records = append(records, binaryRecord{prefix: netip.PrefixFrom(netip.AddrFrom16(raw), ones), info: info})
+	}
+	if br.err != nil {
+		if br.err == io.ErrUnexpectedEOF {
+			return header, nil, fmt.Errorf("%w: truncated checkpoint", ErrChecksumMismatch)
+		}
+		// the records are corrupted
+		return header, nil, fmt.Errorf("%w: %v", ErrChecksumMismatch, br.err)
+	}
+	trailer := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, trailer); err != nil {
+		return header, nil, fmt.Errorf("%w: missing trailer", ErrChecksumMismatch)
+	}
+	if !bytes.Equal(trailer, br.checksum.Sum(nil)) {
+		return header, nil, ErrChecksumMismatch
+	}
+	if n, _ := r.Read(make([]byte, 1)); n != 0 {
+		return header, nil, fmt.Errorf("%w: trailing data after the checksum", ErrChecksumMismatch)
+	}
+	return header, records, nil
+}
+
+// isBinaryCheckpoint reports whether the reader starts with the magic of a
+// binary checkpoint without consuming it.
+func isBinaryCheckpoint(r *bufio.Reader) bool {
+	magic, err := r.Peek(len(binaryMagic))
+	return err == nil && string(magic) == binaryMagic
+}
+
+// readBinaryRecords reads the alias prefixes of a binary checkpoint. Alias
+// prefixes without attributes get attributes with the given source.
+func readBinaryRecords(r io.Reader, source string) ([]binaryRecord, error) {
+	_, records, err := readBinary(r)
+	if err != nil {
+		return nil, err
+	}
+	importTime := time.Now()
+	for i := range records {
+		if records[i].info == nil {
+			records[i].info = NewLeafInfo(source, importTime, nil)
+		}
+	}
+	return records, nil
+}
+
+// readBinaryPrefixes calls insert with each alias prefix of a binary
+// checkpoint. Nothing is inserted if the checkpoint is corrupted.
+func readBinaryPrefixes(r io.Reader, source string, insert func(prefix netip.Prefix, info *LeafInfo)) (int, error) {
+	records, err := readBinaryRecords(r, source)
+	if err != nil {
+		return 0, err
+	}
+	for _, record := range records {
+		insert(record.prefix, record.info)
+	}
+	return len(records), nil
+}
+
+// canonicalRecords reports whether the alias prefixes of records are masked,
+// sorted and do not overlap, as in a checkpoint written from a tree.
+func canonicalRecords(records []binaryRecord) bool {
+	for i, record := range records {
+		if record.prefix != record.prefix.Masked() {
+			return false
+		}
+		if i == 0 {
+			continue
+		}
+		previous := records[i-1].prefix
+		k, o := keyOf(previous.Addr()), keyOf(record.prefix.Addr())
+		common := k.commonPrefixLen(o)
+		if common >= previous.Bits() || common >= record.prefix.Bits() || k.bit(common) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// buildNode returns the node starting at bit start which holds the sorted,
+// non-overlapping records.
+func (t *Radix) buildNode(records []binaryRecord, start int) *Node {
+	first := keyOf(records[0].prefix.Addr())
+	if len(records) == 1 {
+		ones := records[0].prefix.Bits()
+		return &Node{
+			gen:         t.gen,
+			isLeaf:      true,
+			startPrefix: uint8(start),
+			endPrefix:   uint8(ones),
+			length:      uint8(ones - start),
+			value:       first,
+			info:        records[0].info,
+		}
+	}
+	// the first and the last records differ at the first bit which is not
+	// common to all of them
+	common := first.commonPrefixLen(keyOf(records[len(records)-1].prefix.Addr()))
+	split := sort.Search(len(records), func(i int) bool {
+		return keyOf(records[i].prefix.Addr()).bit(common) == 1
+	})
+	return &Node{
+		gen:         t.gen,
+		startPrefix: uint8(start),
+		endPrefix:   uint8(common),
+		length:      uint8(common - start),
+		value:       first,
+		children:    []*Node{t.buildNode(records[:split], common), t.buildNode(records[split:], common)},
+	}
+}
+
+// loadBinary loads the alias prefixes of a binary checkpoint into the empty
+// tree. The nodes are built directly from the alias prefixes of a checkpoint
+// written from a tree, which are sorted and do not overlap, without looking
+// for new aliases. Other checkpoints are inserted prefix by prefix. Nothing is
+// loaded if the checkpoint is corrupted.
+func (t *Radix) loadBinary(r io.Reader, source string) (int, error) {
+	records, err := readBinaryRecords(r, source)
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+	if !canonicalRecords(records) {
+		for _, record := range records {
+			t.insert(record.prefix, record.info)
+		}
+		return len(records), nil
+	}
+	root := t.mutableRoot()
+	if top := t.buildNode(records, 0); !top.isLeaf && top.endPrefix == 0 {
+		// the alias prefixes differ from the first bit
+		root.children = top.children
+	} else {
+		root.children = []*Node{top}
+	}
+	root.isLeaf = false
+	t.isChanged.Store(true)
+	return len(records), nil
+}
+
+// ReadBinaryCheckpointHeader returns the header of a binary checkpoint.
+func ReadBinaryCheckpointHeader(r io.Reader) (BinaryCheckpointHeader, error) {
+	br := &binaryReader{r: r, checksum: sha256.New()}
+	header := br.header()
+	return header, br.err
+}
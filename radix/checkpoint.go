@@ -0,0 +1,372 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package radix
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// latestSuffix is appended to the checkpoint base name for the symbolic
+// link which points to the newest checkpoint.
+const latestSuffix = "latest"
+
+// checkpointName returns the file name of a checkpoint created at checkpointTime.
+func checkpointName(checkpointBaseName string, checkpointTime time.Time) string {
+	return fmt.Sprintf("%s-%s", checkpointBaseName, checkpointTime.Format(time.RFC3339))
+}
+
+// parseCheckpointName returns the creation time of a checkpoint from its file
+// name. ok is false if the name is not a checkpoint of the given base name.
+func parseCheckpointName(checkpointBaseName, name string) (checkpointTime time.Time, ok bool) {
+	suffix, found := strings.CutPrefix(name, filepath.Base(checkpointBaseName)+"-")
+	if !found {
+		return time.Time{}, false
+	}
+	checkpointTime, err := time.Parse(time.RFC3339, suffix)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return checkpointTime, true
+}
+
+// checkpointInfo is a checkpoint file found on the disk.
+type checkpointInfo struct {
+	path string
+	time time.Time
+}
+
+// listCheckpoints returns the checkpoints with the given base name, newest
+// first. Temporary files of checkpoints which are still being written, or
+// never completed, are not listed.
+func listCheckpoints(checkpointBaseName string) ([]checkpointInfo, error) {
+	dir := filepath.Dir(checkpointBaseName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints := []checkpointInfo{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		checkpointTime, ok := parseCheckpointName(checkpointBaseName, entry.Name())
+		if !ok {
+			continue
+		}
+		checkpoints = append(checkpoints, checkpointInfo{path: filepath.Join(dir, entry.Name()), time: checkpointTime})
+	}
+	sort.SliceStable(checkpoints, func(i, j int) bool {
+		return checkpoints[i].time.After(checkpoints[j].time)
+	})
+	return checkpoints, nil
+}
+
+// FindLatestCheckpoint returns the path and creation time of the newest
+// checkpoint with the given base name. The path is empty if there is no
+// checkpoint.
+func FindLatestCheckpoint(checkpointBaseName string) (string, time.Time, error) {
+	checkpoints, err := listCheckpoints(checkpointBaseName)
+	if err != nil || len(checkpoints) == 0 {
+		return "", time.Time{}, err
+	}
+	return checkpoints[0].path, checkpoints[0].time, nil
+}
+
+// writeText writes the alias prefixes visited by walk in the text checkpoint
+// format, one prefix and its attributes per line.
+func writeText(buf *bufio.Writer, walk func(fn func(prefix netip.Prefix, info *LeafInfo) bool)) error {
+	var err error
+	walk(func(prefix netip.Prefix, info *LeafInfo) bool {
+		var line string
+		if line, err = FormatCheckpointLine(prefix, info); err == nil {
+			if _, err = buf.WriteString(line); err == nil {
+				err = buf.WriteByte('\n')
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+// WritePrefixes writes count alias prefixes, visited in address order by
+// walk, to w in the given checkpoint format. createdAt is recorded in the
+// header of binary checkpoints. The attributes passed to fn are only read.
+func WritePrefixes(w io.Writer, format string, createdAt time.Time, count int, walk func(fn func(prefix netip.Prefix, info *LeafInfo) bool)) error {
+	buf := bufio.NewWriter(w)
+	var err error
+	if format == FormatBinary {
+		err = writeBinary(buf, createdAt, count, walk)
+	} else {
+		err = writeText(buf, walk)
+	}
+	if err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// walkPrefixes calls fn with the prefix and the attributes of each alias
+// leaf in address order until fn returns false.
+func (t *Radix) walkPrefixes(fn func(prefix netip.Prefix, info *LeafInfo) bool) {
+	t.walkLeaves(netip.Prefix{}, func(leaf *Node) bool {
+		return fn(leaf.prefix(), leaf.info)
+	})
+}
+
+// Export writes the alias prefixes in the tree to w in the given checkpoint
+// format. createdAt is recorded in the header of binary checkpoints.
+func (t *Radix) Export(w io.Writer, format string, createdAt time.Time) error {
+	// Export a snapshot, the header of binary checkpoints counts the
+	// prefixes before they are written.
+	t = t.Snapshot()
+	return WritePrefixes(w, format, createdAt, t.Count(), t.walkPrefixes)
+}
+
+// CheckpointSettings are the name, the format and the retention policy of
+// the checkpoints of a store.
+type CheckpointSettings struct {
+	BaseName string
+	Format   string
+	// Keep is the number of newest checkpoints to keep, 0 keeps every one.
+	Keep int
+	// MaxAge is the age after which checkpoints are removed, 0 keeps every one.
+	MaxAge time.Duration
+}
+
+// checkpointSettings returns the checkpoint settings of the tree.
+func (t *Radix) checkpointSettings() CheckpointSettings {
+	return CheckpointSettings{
+		BaseName: t.checkpointBaseName,
+		Format:   t.checkpointFormat,
+		Keep:     t.checkpointKeep,
+		MaxAge:   t.checkpointMaxAge,
+	}
+}
+
+// writeCheckpoint writes the alias prefixes of store to a temporary file
+// next to the final checkpoint and syncs it to the disk. The caller renames
+// the returned file once it is complete, so that a partially written
+// checkpoint never carries a checkpoint name.
+func writeCheckpoint(store Store, settings CheckpointSettings, checkpointTime time.Time) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(settings.BaseName), "."+filepath.Base(settings.BaseName)+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	err = store.Export(tmp, settings.Format, checkpointTime)
+	if err == nil {
+		// Temporary files are only readable by the owner, checkpoints are not.
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// syncDir flushes a directory entry change (e.g. a rename) to the disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// linkLatest atomically points the <base name>-latest symbolic link to the
+// given checkpoint.
+func linkLatest(checkpointBaseName, checkpointFile string) error {
+	link := fmt.Sprintf("%s-%s", checkpointBaseName, latestSuffix)
+	tmpLink := fmt.Sprintf("%s.tmp", link)
+	os.Remove(tmpLink)
+	if err := os.Symlink(filepath.Base(checkpointFile), tmpLink); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpLink, link); err != nil {
+		os.Remove(tmpLink)
+		return err
+	}
+	return nil
+}
+
+// pruneCheckpoints removes the checkpoints which exceed the retention policy.
+// The checkpoint that was just written is always kept.
+func pruneCheckpoints(settings CheckpointSettings, current string, checkpointTime time.Time) error {
+	if settings.Keep <= 0 && settings.MaxAge <= 0 {
+		return nil
+	}
+	checkpoints, err := listCheckpoints(settings.BaseName)
+	if err != nil {
+		return err
+	}
+	kept := 0
+	for _, checkpoint := range checkpoints {
+		if checkpoint.path != current {
+			if settings.Keep > 0 && kept >= settings.Keep {
+				log.Infof("removing checkpoint %s, keeping the newest %d", checkpoint.path, settings.Keep)
+				if err := os.Remove(checkpoint.path); err != nil {
+					return err
+				}
+				continue
+			}
+			if settings.MaxAge > 0 && checkpointTime.Sub(checkpoint.time) > settings.MaxAge {
+				log.Infof("removing checkpoint %s, it is older than %s", checkpoint.path, settings.MaxAge)
+				if err := os.Remove(checkpoint.path); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		kept++
+	}
+	return nil
+}
+
+// WriteCheckpoint writes the alias prefixes of store, which should not be
+// modified meanwhile (e.g. a View), to a new checkpoint named after
+// checkpointTime. The checkpoint is written to a temporary file which is
+// synced and atomically renamed, so a crash or a full disk never leaves a
+// partial checkpoint behind. Afterwards the <base name>-latest link is
+// updated and old checkpoints are pruned according to the retention policy.
+func WriteCheckpoint(store Store, settings CheckpointSettings, checkpointTime time.Time) error {
+	checkpointFile := checkpointName(settings.BaseName, checkpointTime)
+	tmp, err := writeCheckpoint(store, settings, checkpointTime)
+	if err != nil {
+		return fmt.Errorf("cannot write checkpoint %s: %w", checkpointFile, err)
+	}
+	if err := os.Rename(tmp, checkpointFile); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("cannot write checkpoint %s: %w", checkpointFile, err)
+	}
+	if err := syncDir(filepath.Dir(checkpointFile)); err != nil {
+		log.Warnf("cannot sync checkpoint directory: %v", err)
+	}
+	if err := linkLatest(settings.BaseName, checkpointFile); err != nil {
+		log.Warnf("cannot link the latest checkpoint: %v", err)
+	}
+	if err := pruneCheckpoints(settings, checkpointFile, checkpointTime); err != nil {
+		log.Warnf("cannot prune old checkpoints: %v", err)
+	}
+	return nil
+}
+
+// ExportCheckpoint writes the alias prefixes in the tree to a new checkpoint
+// named after checkpointTime with WriteCheckpoint, in the text or binary
+// format set with SetCheckpointFormat. The checkpoint is exported from a
+// snapshot, so the tree can be modified meanwhile. The tree is marked as
+// unchanged when the snapshot is taken, and marked as changed again if the
+// checkpoint cannot be written.
+func (t *Radix) ExportCheckpoint(checkpointTime time.Time) error {
+	t.writer.Lock()
+	snapshot := t.Snapshot()
+	t.setChange(false)
+	newAliasFound := t.constructionNewAliasFound.Swap(false)
+	t.writer.Unlock()
+	if err := WriteCheckpoint(snapshot, t.checkpointSettings(), checkpointTime); err != nil {
+		t.setChange(true)
+		if newAliasFound {
+			t.constructionNewAliasFound.Store(true)
+		}
+		return err
+	}
+	return nil
+}
+
+// ImportPrefixes reads alias prefixes from a construct input file or a
+// checkpoint and inserts them into the tree. The checkpoint format is
+// detected automatically. Prefixes without attributes are inserted with the
+// given source. Empty lines are skipped. It returns the number of imported
+// prefixes. The imported prefixes are published at once when the import
+// ends, including the ones imported before an error. A binary checkpoint
+// imported into an empty tree is loaded as it is, without looking for new
+// aliases.
+func (t *Radix) ImportPrefixes(r io.Reader, source string) (int, error) {
+	var counter int
+	var err error
+	reader := bufio.NewReader(r)
+	t.update(func(draft *Radix) {
+		if root := draft.rootNode(); (root.isLeaf || len(root.children) == 0) && isBinaryCheckpoint(reader) {
+			counter, err = draft.loadBinary(reader, source)
+			return
+		}
+		counter, err = ReadPrefixes(reader, source, func(prefix netip.Prefix, info *LeafInfo) {
+			draft.insert(prefix, info)
+		})
+	})
+	return counter, err
+}
+
+// ReadPrefixes reads alias prefixes from a construct input file or a
+// checkpoint and calls insert with each of them. The checkpoint format is
+// detected automatically. Prefixes without attributes get attributes with
+// the given source. Empty lines are skipped. It returns the number of read
+// prefixes.
+func ReadPrefixes(r io.Reader, source string, insert func(prefix netip.Prefix, info *LeafInfo)) (int, error) {
+	reader := bufio.NewReader(r)
+	if isBinaryCheckpoint(reader) {
+		return readBinaryPrefixes(reader, source, insert)
+	}
+	// Lines are read whole, whatever the length of their attributes.
+	importTime := time.Now()
+	counter := 0
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return counter, err
+		}
+		if strings.TrimSpace(line) != "" {
+			prefix, info, parseErr := ParseCheckpointLine(strings.TrimRight(line, "\r\n"))
+			if parseErr != nil {
+				return counter, fmt.Errorf("line %d: %w", lineNumber, parseErr)
+			}
+			if info == nil {
+				info = NewLeafInfo(source, importTime, nil)
+			}
+			insert(prefix, info)
+			counter++
+		}
+		if err == io.EOF {
+			return counter, nil
+		}
+	}
+}
+
+// ImportPrefixFile reads alias prefixes from the given file with ImportPrefixes.
+func (t *Radix) ImportPrefixFile(filename, source string) (int, error) {
+	fin, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer fin.Close()
+	return t.ImportPrefixes(fin, source)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package radix
+
+import (
+	"math"
+	"net/netip"
+)
+
+// Coverage values of a prefix lookup.
+const (
+	CoverageFull    = "full"
+	CoveragePartial = "partial"
+	CoverageNone    = "none"
+)
+
+// Coverage describes how much of a prefix is covered by alias prefixes.
+type Coverage struct {
+	Coverage string `json:"coverage"`
+	// Prefixes are the alias prefixes overlapping with the prefix. It is the
+	// single covering alias prefix when the coverage is full.
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Fraction is the fraction of the addresses in the prefix which are
+	// covered by alias prefixes.
+	Fraction float64 `json:"fraction"`
+	// Info holds the attributes of the covering alias prefix when the
+	// coverage is full.
+	Info *LeafInfo `json:"info,omitempty"`
+}
+
+// LookUpPrefix reports whether prefix is fully, partially or not covered by
+// the alias prefixes in the tree without visiting each address of prefix.
+func (t *Radix) LookUpPrefix(prefix netip.Prefix) Coverage {
+	// Both lookups below have to see the same tree.
+	t = t.Snapshot()
+	prefix = normalizePrefix(prefix)
+	ones := prefix.Bits()
+	if covering := t.lookupNode(keyOf(prefix.Addr())); covering != nil && int(covering.endPrefix) <= ones {
+		return Coverage{
+			Coverage: CoverageFull,
+			Prefixes: []string{covering.prefixString()},
+			Fraction: 1,
+			Info:     copyInfo(covering.info),
+		}
+	}
+	leaves := t.leavesUnder(prefix)
+	if len(leaves) == 0 {
+		return Coverage{Coverage: CoverageNone}
+	}
+	coverage := Coverage{Coverage: CoveragePartial, Prefixes: make([]string, 0, len(leaves))}
+	for _, leaf := range leaves {
+		coverage.Prefixes = append(coverage.Prefixes, leaf.prefixString())
+		coverage.Fraction += math.Ldexp(1, ones-int(leaf.endPrefix))
+	}
+	return coverage
+}
+
+// leafLabel returns the label of an alias leaf with a copy of its attributes.
+func leafLabel(leaf *Node) Label {
+	return Label{Aliased: true, Metadata: leaf.prefixString(), Info: copyInfo(leaf.info)}
+}
+
+// Covering returns the alias prefixes which contain prefix, including prefix
+// itself if it is an alias prefix. Alias prefixes in the tree never overlap,
+// so there is at most one.
+func (t *Radix) Covering(prefix netip.Prefix) []Label {
+	labels := []Label{}
+	prefix = normalizePrefix(prefix)
+	if leaf := t.lookupNode(keyOf(prefix.Addr())); leaf != nil && int(leaf.endPrefix) <= prefix.Bits() {
+		labels = append(labels, leafLabel(leaf))
+	}
+	return labels
+}
+
+// CoveredBy returns the alias prefixes inside prefix, including prefix itself
+// if it is an alias prefix, in address order.
+func (t *Radix) CoveredBy(prefix netip.Prefix) []Label {
+	leaves := t.leavesUnder(normalizePrefix(prefix))
+	labels := make([]Label, 0, len(leaves))
+	for _, leaf := range leaves {
+		labels = append(labels, leafLabel(leaf))
+	}
+	return labels
+}
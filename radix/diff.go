@@ -0,0 +1,117 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package radix
+
+import (
+	"math/big"
+	"net/netip"
+)
+
+// Types of changes between two trees
+const (
+	ChangeAdded              = "added"                 // New alias prefix which does not overlap with the old tree
+	ChangeRemoved            = "removed"               // Old alias prefix which does not overlap with the new tree
+	ChangeCoveredByNewParent = "covered-by-new-parent" // Old alias prefix merged into a shorter new alias prefix
+	ChangeNewlyCovering      = "newly-covering"        // New alias prefix which covers shorter old alias prefixes
+	ChangeSplit              = "split"                 // Old alias prefix replaced with longer new alias prefixes
+)
+
+// Change is a difference between two trees.
+type Change struct {
+	Type    string   `json:"change"`
+	Prefix  string   `json:"prefix"`
+	Related []string `json:"related,omitempty"`
+}
+
+// DiffSummary contains the number of changes per change type, and the
+// difference in the address space covered by the alias prefixes of each
+// prefix length (new minus old), in number of addresses.
+type DiffSummary struct {
+	Changes      map[string]int   `json:"changes"`
+	AddressDelta map[int]*big.Int `json:"address_delta"`
+}
+
+// allLeaves returns the alias leaves of the tree in address order.
+func (t *Radix) allLeaves() []*Node {
+	return t.leavesUnder(netip.Prefix{})
+}
+
+// addressSpace adds the number of addresses in each leaf to the sum of its
+// prefix length, multiplied by sign.
+func addressSpace(leaves []*Node, sums map[int]*big.Int, sign int64) {
+	for _, leaf := range leaves {
+		size := new(big.Int).Lsh(big.NewInt(sign), uint(128-int(leaf.endPrefix)))
+		ones := int(leaf.endPrefix)
+		if _, ok := sums[ones]; !ok {
+			sums[ones] = new(big.Int)
+		}
+		sums[ones].Add(sums[ones], size)
+	}
+}
+
+// Diff compares the alias prefixes of two trees and calls report for every
+// change, first for the prefixes of the old tree and then for the prefixes of
+// the new tree, each in address order. Identical alias prefixes are not
+// reported.
+func Diff(oldTree, newTree *Radix, report func(change Change)) DiffSummary {
+	summary := DiffSummary{
+		Changes:      map[string]int{},
+		AddressDelta: map[int]*big.Int{},
+	}
+	emit := func(change Change) {
+		summary.Changes[change.Type]++
+		report(change)
+	}
+	oldLeaves := oldTree.allLeaves()
+	newLeaves := newTree.allLeaves()
+
+	for _, leaf := range oldLeaves {
+		prefix := leaf.prefixString()
+		covering := newTree.lookupNode(leaf.value)
+		if covering != nil && covering.endPrefix == leaf.endPrefix {
+			continue
+		}
+		if covering != nil && covering.endPrefix < leaf.endPrefix {
+			emit(Change{Type: ChangeCoveredByNewParent, Prefix: prefix, Related: []string{covering.prefixString()}})
+		} else if covered := newTree.leafPrefixesUnder(leaf.prefix()); len(covered) > 0 {
+			emit(Change{Type: ChangeSplit, Prefix: prefix, Related: covered})
+		} else {
+			emit(Change{Type: ChangeRemoved, Prefix: prefix})
+		}
+	}
+	for _, leaf := range newLeaves {
+		prefix := leaf.prefixString()
+		covering := oldTree.lookupNode(leaf.value)
+		if covering != nil && covering.endPrefix <= leaf.endPrefix {
+			// Either identical or already reported as a split of the old prefix.
+			continue
+		}
+		if covered := oldTree.leafPrefixesUnder(leaf.prefix()); len(covered) > 0 {
+			emit(Change{Type: ChangeNewlyCovering, Prefix: prefix, Related: covered})
+		} else {
+			emit(Change{Type: ChangeAdded, Prefix: prefix})
+		}
+	}
+
+	addressSpace(newLeaves, summary.AddressDelta, 1)
+	addressSpace(oldLeaves, summary.AddressDelta, -1)
+	for ones, delta := range summary.AddressDelta {
+		if delta.Sign() == 0 {
+			delete(summary.AddressDelta, ones)
+		}
+	}
+	return summary
+}
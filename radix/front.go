@@ -0,0 +1,211 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package radix
+
+import (
+	"fmt"
+)
+
+// MaxFrontTableBits is the largest number of leading bits a front table
+// can be indexed with.
+const MaxFrontTableBits = 24
+
+// frontTable is a direct-indexed table in front of the tree, in the spirit
+// of DIR-24-8: it is indexed with the first bits of an address and holds
+// the node a lookup of the address can start from, which saves the
+// traversal of the top of the tree.
+//
+// The entry of an index is:
+//   - the alias leaf covering every address of the index, or
+//   - the deepest node ending at or before the indexed bits whose prefix
+//     contains the index, if a child of the node continues past the indexed
+//     bits, or
+//   - nil if no alias prefix overlaps with the index.
+//
+// Like the nodes, a published table is never modified. The entries are
+// split into chunks so a modification of the tree only copies the chunks
+// whose entries change.
+type frontTable struct {
+	bits      int
+	chunkBits int
+	// root is the root of the version of the tree the entries point into.
+	root *Node
+	// chunks holds the entries, a nil chunk has only nil entries.
+	chunks [][]*Node
+}
+
+// newFrontTable returns an empty table indexed with the first bits of the
+// addresses for the tree with the given root.
+func newFrontTable(bits int, root *Node) *frontTable {
+	if bits < 1 || bits > MaxFrontTableBits {
+		panic(fmt.Sprintf("front table bits should be between 1 and %d: %d", MaxFrontTableBits, bits))
+	}
+	table := &frontTable{bits: bits, chunkBits: bits / 2}
+	table.chunks = make([][]*Node, 1<<(bits-table.chunkBits))
+	table.root = &Node{isLeaf: true}
+	return table.update(root)
+}
+
+// index returns the index of k in the table.
+func (table *frontTable) index(k key) uint64 {
+	return k.hi >> (64 - table.bits)
+}
+
+// entry returns the entry of k.
+func (table *frontTable) entry(k key) *Node {
+	index := table.index(k)
+	chunk := table.chunks[index>>table.chunkBits]
+	if chunk == nil {
+		return nil
+	}
+	return chunk[index&(1<<table.chunkBits-1)]
+}
+
+// frontDraft is a table being updated for a new version of the tree.
+type frontDraft struct {
+	*frontTable
+	// owned reports which chunks were copied for the update.
+	owned []bool
+}
+
+// set sets the entry of index to n.
+func (d *frontDraft) set(index uint64, n *Node) {
+	c := index >> d.chunkBits
+	if !d.owned[c] {
+		if d.chunks[c] == nil && n == nil {
+			return
+		}
+		chunk := make([]*Node, 1<<d.chunkBits)
+		copy(chunk, d.chunks[c])
+		d.chunks[c] = chunk
+		d.owned[c] = true
+	}
+	d.chunks[c][index&(1<<d.chunkBits-1)] = n
+}
+
+// setRange sets size entries starting at index to n.
+func (d *frontDraft) setRange(index, size uint64, n *Node) {
+	for i := index; i < index+size; i++ {
+		d.set(i, n)
+	}
+}
+
+// footprint returns the first index and the number of entries covered by
+// the prefix of n.
+func (d *frontDraft) footprint(n *Node) (uint64, uint64) {
+	if int(n.endPrefix) >= d.bits {
+		return d.index(n.value), 1
+	}
+	return d.index(n.value.mask(int(n.endPrefix))), 1 << (d.bits - int(n.endPrefix))
+}
+
+// fill sets the entries covered by n, a child of parent.
+func (d *frontDraft) fill(parent, n *Node) {
+	index, size := d.footprint(n)
+	switch {
+	case int(n.endPrefix) > d.bits:
+		d.set(index, parent)
+	case n.isLeaf:
+		d.setRange(index, size, n)
+	case int(n.endPrefix) == d.bits:
+		d.set(index, n)
+	default:
+		d.setRange(index, size, nil)
+		for _, child := range n.children {
+			d.fill(n, child)
+		}
+	}
+}
+
+// clear removes the entries covered by n.
+func (d *frontDraft) clear(n *Node) {
+	index, size := d.footprint(n)
+	d.setRange(index, size, nil)
+}
+
+// childAt returns the child of n whose next bit is bit, or nil.
+func childAt(n *Node, bit uint64) *Node {
+	for _, child := range n.children {
+		if child.value.bit(int(n.endPrefix)) == bit {
+			return child
+		}
+	}
+	return nil
+}
+
+// diff updates the entries covered by an internal node from its old version
+// to its new version. Sub-trees shared by both versions are skipped, so only
+// the entries of the modified paths are visited.
+func (d *frontDraft) diff(before, after *Node) {
+	if int(after.endPrefix) == d.bits {
+		index, _ := d.footprint(after)
+		d.set(index, after)
+		return
+	}
+	for bit := uint64(0); bit < 2; bit++ {
+		oldChild, newChild := childAt(before, bit), childAt(after, bit)
+		if oldChild == newChild {
+			if newChild != nil && int(newChild.endPrefix) > d.bits {
+				// The entry points to the parent, which was replaced.
+				d.fill(after, newChild)
+			}
+			continue
+		}
+		if oldChild != nil && newChild != nil && !oldChild.isLeaf && !newChild.isLeaf &&
+			oldChild.endPrefix == newChild.endPrefix && int(newChild.endPrefix) <= d.bits &&
+			oldChild.value.mask(int(oldChild.endPrefix)) == newChild.value.mask(int(newChild.endPrefix)) {
+			d.diff(oldChild, newChild)
+			continue
+		}
+		if oldChild != nil {
+			d.clear(oldChild)
+		}
+		if newChild != nil {
+			d.fill(after, newChild)
+		}
+	}
+}
+
+// update returns the table for root, a newer version of the tree.
+func (table *frontTable) update(root *Node) *frontTable {
+	if root == table.root {
+		return table
+	}
+	d := &frontDraft{
+		frontTable: &frontTable{
+			bits:      table.bits,
+			chunkBits: table.chunkBits,
+			root:      root,
+			chunks:    append([][]*Node(nil), table.chunks...),
+		},
+		owned: make([]bool, len(table.chunks)),
+	}
+	switch {
+	case root.isLeaf:
+		// The tree is empty.
+		d.chunks = make([][]*Node, len(table.chunks))
+	case table.root.isLeaf || table.root.hasDefaultLeaf() || root.hasDefaultLeaf():
+		// The tree was empty, or the default prefix covers every entry
+		// before or after the update: the entries are filled again.
+		d.chunks = make([][]*Node, len(table.chunks))
+		for _, child := range root.children {
+			d.fill(root, child)
+		}
+	default:
+		d.diff(table.root, root)
+	}
+	return d.frontTable
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package radix
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Sources of an alias prefix
+const (
+	SourceConstruct = "construct" // Read from the construct input file
+	SourceInsert    = "insert"    // Received with an insert command
+	SourceMerge     = "merge"     // Inferred by the aggregation policy
+)
+
+// LeafInfo contains the attributes of an alias prefix in the tree.
+type LeafInfo struct {
+	Source        string            `json:"source"`
+	FirstSeen     time.Time         `json:"first_seen"`
+	LastConfirmed time.Time         `json:"last_confirmed"`
+	Tags          map[string]string `json:"tags,omitempty"`
+	MergedFrom    []string          `json:"merged_from,omitempty"`
+}
+
+// NewLeafInfo returns a LeafInfo for an alias prefix first seen at the given time.
+func NewLeafInfo(source string, seen time.Time, tags map[string]string) *LeafInfo {
+	return &LeafInfo{
+		Source:        source,
+		FirstSeen:     seen,
+		LastConfirmed: seen,
+		Tags:          tags,
+	}
+}
+
+// confirm updates the last confirmation time of the alias prefix and
+// adds the given tags. It returns whether anything changed.
+func (info *LeafInfo) confirm(confirmed time.Time, tags map[string]string) bool {
+	changed := false
+	if confirmed.After(info.LastConfirmed) {
+		info.LastConfirmed = confirmed
+		changed = true
+	}
+	if len(tags) > 0 {
+		// The tag map may be shared with lookup results, never update it in place.
+		merged := make(map[string]string, len(info.Tags)+len(tags))
+		for k, v := range info.Tags {
+			merged[k] = v
+		}
+		for k, v := range tags {
+			if merged[k] != v {
+				merged[k] = v
+				changed = true
+			}
+		}
+		info.Tags = merged
+	}
+	return changed
+}
+
+// Confirm updates the last confirmation time of the alias prefix and adds
+// the given tags. It returns whether anything changed. The attributes of an
+// alias prefix in a store are shared with its snapshots, so the store
+// confirms a copy of them.
+func (info *LeafInfo) Confirm(confirmed time.Time, tags map[string]string) bool {
+	return info.confirm(confirmed, tags)
+}
+
+// AppendJSON appends the JSON encoding of the attributes to b. The output is
+// the same as json.Marshal, but it does not allocate beyond growing b.
+func (info *LeafInfo) AppendJSON(b []byte) []byte {
+	b = append(b, `{"source":`...)
+	b = appendJSONString(b, info.Source)
+	b = append(b, `,"first_seen":"`...)
+	b = info.FirstSeen.AppendFormat(b, time.RFC3339Nano)
+	b = append(b, `","last_confirmed":"`...)
+	b = info.LastConfirmed.AppendFormat(b, time.RFC3339Nano)
+	b = append(b, '"')
+	if len(info.Tags) > 0 {
+		b = append(b, `,"tags":{`...)
+		// Tags are written in key order like json.Marshal. There are only a
+		// few of them, so the smallest remaining key is searched each time
+		// instead of sorting a copy of the keys.
+		previous := ""
+		for i := 0; i < len(info.Tags); i++ {
+			next, found := "", false
+			for k := range info.Tags {
+				if (i == 0 || k > previous) && (!found || k < next) {
+					next, found = k, true
+				}
+			}
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, next)
+			b = append(b, ':')
+			b = appendJSONString(b, info.Tags[next])
+			previous = next
+		}
+		b = append(b, '}')
+	}
+	if len(info.MergedFrom) > 0 {
+		b = append(b, `,"merged_from":[`...)
+		for i, prefix := range info.MergedFrom {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, prefix)
+		}
+		b = append(b, ']')
+	}
+	return append(b, '}')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as a JSON string to b, escaped like json.Marshal
+// escapes it.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+			start = i + size
+		case r == '\u2028' || r == '\u2029':
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			start = i + size
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
+
+// FormatCheckpointLine returns the checkpoint line of an alias prefix:
+// the prefix in CIDR notation, optionally followed by a tab and its
+// attributes in JSON format. IPv4-mapped prefixes are written as IPv4
+// prefixes.
+func FormatCheckpointLine(prefix netip.Prefix, info *LeafInfo) (string, error) {
+	prefix = normalizePrefix(prefix)
+	formatted := familyPrefix(keyOf(prefix.Addr()), prefix.Bits()).String()
+	if info == nil {
+		return formatted, nil
+	}
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\t%s", formatted, raw), nil
+}
+
+// ParseCheckpointLine parses a line of a checkpoint or construct input file.
+// The attributes are nil if the line only contains a prefix.
+func ParseCheckpointLine(line string) (netip.Prefix, *LeafInfo, error) {
+	field, attributes, found := strings.Cut(strings.TrimSpace(line), "\t")
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(field))
+	if err != nil {
+		return netip.Prefix{}, nil, err
+	}
+	prefix = prefix.Masked()
+	if !found {
+		return prefix, nil, nil
+	}
+	info := &LeafInfo{}
+	if err := json.Unmarshal([]byte(attributes), info); err != nil {
+		return netip.Prefix{}, nil, fmt.Errorf("cannot parse attributes of %s: %w", field, err)
+	}
+	return prefix, info, nil
+}
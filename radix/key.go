@@ -0,0 +1,107 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package radix
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net/netip"
+)
+
+// key is a 128-bit address stored in the tree, split into its most
+// significant (hi) and least significant (lo) 64 bits. Keeping it inline in
+// the nodes avoids a separate allocation per node, and bit tests are shifts.
+type key struct {
+	hi, lo uint64
+}
+
+// keyOf returns the key of addr. IPv4 addresses are mapped into ::ffff:0:0/96.
+func keyOf(addr netip.Addr) key {
+	raw := addr.As16()
+	return key{hi: binary.BigEndian.Uint64(raw[:8]), lo: binary.BigEndian.Uint64(raw[8:])}
+}
+
+// addr returns k as an IPv6 address.
+func (k key) addr() netip.Addr {
+	var raw [16]byte
+	binary.BigEndian.PutUint64(raw[:8], k.hi)
+	binary.BigEndian.PutUint64(raw[8:], k.lo)
+	return netip.AddrFrom16(raw)
+}
+
+// bit returns the i-th most significant bit (0 or 1) of k.
+func (k key) bit(i int) uint64 {
+	if i < 64 {
+		return (k.hi >> (63 - i)) & 1
+	}
+	return (k.lo >> (127 - i)) & 1
+}
+
+// flip returns k with its i-th most significant bit inverted.
+func (k key) flip(i int) key {
+	if i < 64 {
+		k.hi ^= 1 << (63 - i)
+	} else {
+		k.lo ^= 1 << (127 - i)
+	}
+	return k
+}
+
+// mask returns k with only its first ones bits kept.
+func (k key) mask(ones int) key {
+	switch {
+	case ones <= 0:
+		return key{}
+	case ones < 64:
+		return key{hi: k.hi &^ (^uint64(0) >> ones)}
+	case ones < 128:
+		return key{hi: k.hi, lo: k.lo &^ (^uint64(0) >> (ones - 64))}
+	}
+	return k
+}
+
+// commonPrefixLen returns the number of leading bits k and o have in common.
+func (k key) commonPrefixLen(o key) int {
+	if x := k.hi ^ o.hi; x != 0 {
+		return bits.LeadingZeros64(x)
+	}
+	return 64 + bits.LeadingZeros64(k.lo^o.lo)
+}
+
+// IPv4 addresses and prefixes are stored in the tree as IPv4-mapped IPv6
+// addresses and prefixes, inside ::ffff:0:0/96. A /24 IPv4 prefix is stored
+// as a /120 prefix, so it is aliased along with the IPv4-mapped addresses it
+// covers and never with other IPv6 addresses.
+
+// normalizePrefix returns prefix as a masked 128-bit prefix. IPv4 prefixes
+// are mapped into ::ffff:0:0/96 and their length is extended by 96 bits.
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	if prefix.Addr().Is4() {
+		prefix = netip.PrefixFrom(netip.AddrFrom16(prefix.Addr().As16()), prefix.Bits()+96)
+	}
+	return prefix.Masked()
+}
+
+// familyPrefix returns the prefix of the first ones bits of k. Prefixes
+// inside ::ffff:0:0/96 are returned as IPv4 prefixes, so they are reported in
+// the family they were given in.
+func familyPrefix(k key, ones int) netip.Prefix {
+	addr := k.mask(ones).addr()
+	if addr.Is4In6() && ones >= 96 {
+		return netip.PrefixFrom(addr.Unmap(), ones-96)
+	}
+	return netip.PrefixFrom(addr, ones)
+}
@@ -19,8 +19,10 @@ import (
 	"bufio"
 	"container/list"
 	"fmt"
-	"net"
+	"net/netip"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -28,8 +30,20 @@ import (
 
 // Label contains the lookup label results
 type Label struct {
-	Aliased  bool   `json:"aliased"`
-	Metadata string `json:"metadata,omitempty"`
+	Aliased  bool      `json:"aliased"`
+	Metadata string    `json:"metadata,omitempty"`
+	Info     *LeafInfo `json:"info,omitempty"`
+}
+
+// Match is the alias prefix containing a looked up address. Unlike Label,
+// it is built without allocating.
+type Match struct {
+	// Prefix is the alias prefix, as an IPv4 prefix if it is inside
+	// ::ffff:0:0/96.
+	Prefix netip.Prefix
+	// Info holds the attributes of the alias prefix. It is shared with the
+	// tree and must not be modified.
+	Info *LeafInfo
 }
 
 type Node struct {
@@ -37,16 +51,35 @@ type Node struct {
 	startPrefix uint8
 	endPrefix   uint8
 	length      uint8
-	value       net.IP
+	value       key
 	children    []*Node
+	info        *LeafInfo
+	// gen is the generation of the modification which created the node.
+	gen uint64
 }
 
+// Radix is a copy-on-write tree: a modification copies the nodes on its
+// path instead of updating them, and publishes the new root at once. Nodes
+// reachable from a published root are never modified, so lookups and
+// traversals need no locks and always see a consistent tree.
 type Radix struct {
-	root                      *Node
-	isChanged                 bool
-	constructionNewAliasFound bool
+	root atomic.Pointer[Node]
+	// front is the optional table in front of the tree for lookups. It is
+	// updated along with the root.
+	front atomic.Pointer[frontTable]
+	// writer serializes the modifications of the tree.
+	writer sync.Mutex
+	// gen is the generation of the nodes which can be modified in place by
+	// the current modification. Older nodes are copied before modification.
+	gen                       uint64
+	isChanged                 atomic.Bool
+	constructionNewAliasFound atomic.Bool
+	policy                    AggregationPolicy
 	checkpointBaseName        string
 	checkpointFrequency       float32
+	checkpointKeep            int
+	checkpointFormat          string
+	checkpointMaxAge          time.Duration
 }
 
 func check(e error) {
@@ -55,17 +88,70 @@ func check(e error) {
 	}
 }
 
-func dupIP(ip net.IP) net.IP {
-	dup := make(net.IP, len(ip))
-	copy(dup, ip)
-	return dup
+// generation hands out a distinct generation to every modification of any
+// tree, so nodes shared between trees are never mistaken for new ones.
+var generation atomic.Uint64
+
+// rootNode returns the published root of the tree. Callers that traverse the
+// tree several times should load it once to see the same tree each time.
+func (t *Radix) rootNode() *Node {
+	return t.root.Load()
+}
+
+// mutable returns n if it was created by the current modification, or a
+// copy of n which can be modified otherwise.
+func (t *Radix) mutable(n *Node) *Node {
+	if n.gen == t.gen {
+		return n
+	}
+	dup := *n
+	dup.gen = t.gen
+	dup.children = append([]*Node(nil), n.children...)
+	dup.info = copyInfo(n.info)
+	return &dup
+}
+
+// mutableRoot makes the root of the tree modifiable and returns it.
+func (t *Radix) mutableRoot() *Node {
+	root := t.mutable(t.rootNode())
+	t.root.Store(root)
+	return root
+}
+
+// mutableChild makes the i-th child of the modifiable node n modifiable and
+// returns it.
+func (t *Radix) mutableChild(n *Node, i int) *Node {
+	child := t.mutable(n.children[i])
+	n.children[i] = child
+	return child
+}
+
+// update applies modify to a draft of the tree, which shares the nodes of
+// the tree until they are modified, and then publishes the root of the
+// draft. Readers see the tree either before or after the modification.
+func (t *Radix) update(modify func(draft *Radix)) {
+	t.writer.Lock()
+	defer t.writer.Unlock()
+	draft := &Radix{policy: t.policy, gen: generation.Add(1)}
+	draft.root.Store(t.rootNode())
+	modify(draft)
+	if table := t.front.Load(); table != nil {
+		t.front.Store(table.update(draft.rootNode()))
+	}
+	t.root.Store(draft.rootNode())
+	if draft.isChanged.Load() {
+		t.isChanged.Store(true)
+	}
+	if draft.constructionNewAliasFound.Load() {
+		t.constructionNewAliasFound.Store(true)
+	}
 }
 
 func (t *Radix) traverseBFSRadix() {
 	nodeCounter := 0
 	leafCounter := 0
 	queue := list.New()
-	queue.PushBack(t.root)
+	queue.PushBack(t.rootNode())
 	log.Info("BFS:")
 	for queue.Len() != 0 {
 		front := queue.Front()
@@ -90,122 +176,203 @@ func (t *Radix) createLabel() Label {
 	}
 }
 
-func (t *Radix) lookup(ip net.IP) Label {
-	label := t.createLabel()
-	current := t.root
-	ipBytes := ip.To16()
-	var i, j, k int
-	for i = 0; i < 128; {
-		matchIndex := -1
-		matchCounter := 0
-		for j = 0; j < len(current.children); j++ {
-			matchCounter = 0
-			if i != int(current.children[j].startPrefix) {
+// lookupNode returns the alias leaf that contains k, or nil if k is not
+// covered by any alias prefix.
+func (t *Radix) lookupNode(k key) *Node {
+	current := t.rootNode()
+	i := 0
+	if table := t.front.Load(); table != nil {
+		// Start from the entry of the front table, which may be the answer.
+		entry := table.entry(k)
+		if entry == nil || entry.isLeaf {
+			return entry
+		}
+		current, i = entry, int(entry.endPrefix)
+	}
+	for i < 128 {
+		var match *Node
+		for _, child := range current.children {
+			if int(child.startPrefix) != i {
 				log.Fatalln("start Prefix don't match with current bit index")
-				continue
 			}
-			for k = i; k < int(current.children[j].endPrefix); k++ {
-				fb := ipBytes[k/8] & (128 >> (k % 8))
-				sb := current.children[j].value[k/8] & (128 >> (k % 8))
-				if fb != sb {
-					break
-				}
-				matchCounter++
-				matchIndex = j
-			}
-			if matchIndex != -1 {
-				if matchCounter != int(current.children[j].length) {
-					// Partial matches does not indicate alias
-					// Go back and check other kids.
-					matchIndex = -1
-					matchCounter = 0
-					continue
-				}
+			if child.matchesBit(k, i) {
+				match = child
 				break
 			}
 		}
-		if matchIndex == -1 {
+		// Partial matches do not indicate alias.
+		if match == nil || match.value.commonPrefixLen(k) < int(match.endPrefix) {
 			// No match could be found with any of the children.
-			label.Aliased = false
-			label.Metadata = ""
-			return label
-		} else {
-			if current.children[matchIndex].isLeaf {
-				// Fully matched, label it as aliased and return the matched prefix
-				label.Aliased = true
-				label.Metadata = fmt.Sprintf("%s/%d", current.children[matchIndex].value, current.children[matchIndex].endPrefix)
-				return label
-			} else {
-				// We can go deeper in the tree, look for further matches until we arrive at a leaf node.
-				i = i + matchCounter
-				current = current.children[matchIndex]
-			}
+			return nil
+		}
+		if match.isLeaf {
+			// Fully matched, this is the alias prefix covering k.
+			return match
+		}
+		// We can go deeper in the tree, look for further matches until we arrive at a leaf node.
+		i = int(match.endPrefix)
+		current = match
+	}
+	return nil
+}
+
+func (t *Radix) lookup(addr netip.Addr) Label {
+	label := t.createLabel()
+	if leaf := t.lookupNode(keyOf(addr)); leaf != nil {
+		// Fully matched, label it as aliased and return the matched prefix
+		label.Aliased = true
+		label.Metadata = leaf.prefixString()
+		if leaf.info != nil {
+			// Return a copy since the attributes may be updated after the lookup.
+			info := *leaf.info
+			label.Info = &info
 		}
 	}
 	return label
 }
 
-func (n *Node) exportCheckpointDFS(buf *bufio.Writer) {
-	if n.isLeaf {
-		if _, err := buf.WriteString(fmt.Sprintf("%s/%d", n.value, n.endPrefix)); err != nil {
-			log.Fatal(err)
+// lookupBatch looks up addrs on the same version of the tree. The nodes on
+// the path to the previous address are kept, so the traversal of an address
+// starts below the last node whose prefix it shares with the previous
+// address. Addresses in sorted order share the longest paths.
+func (t *Radix) lookupBatch(addrs []netip.Addr) []Label {
+	labels := make([]Label, len(addrs))
+	path := []*Node{t.rootNode()}
+	var previous key
+	var leaf *Node
+	for i, addr := range addrs {
+		k := keyOf(addr)
+		if i > 0 {
+			common := k.commonPrefixLen(previous)
+			if leaf != nil && common >= int(leaf.endPrefix) {
+				// Inside the same alias prefix as the previous address.
+				labels[i] = labels[i-1]
+				continue
+			}
+			for len(path) > 1 && int(path[len(path)-1].endPrefix) > common {
+				path = path[:len(path)-1]
+			}
 		}
-		if err := buf.WriteByte('\n'); err != nil {
-			log.Fatal(err)
+		previous = k
+		leaf = nil
+		for current := path[len(path)-1]; !current.isLeaf; {
+			bit := int(current.endPrefix)
+			var match *Node
+			for _, child := range current.children {
+				if child.matchesBit(k, bit) {
+					match = child
+					break
+				}
+			}
+			if match == nil || match.value.commonPrefixLen(k) < int(match.endPrefix) {
+				break
+			}
+			if match.isLeaf {
+				leaf = match
+				break
+			}
+			path = append(path, match)
+			current = match
 		}
-		buf.Flush()
-	} else {
-		for i := 0; i < len(n.children); i++ {
-			n.children[i].exportCheckpointDFS(buf)
+		if leaf != nil {
+			labels[i] = leafLabel(leaf)
+		} else {
+			labels[i] = t.createLabel()
 		}
 	}
+	return labels
 }
 
-func (t *Radix) ExportCheckpoint(checkpointTime time.Time) {
-	var err error
-	var checkpointFile *os.File
-	if checkpointFile, err = os.Create(fmt.Sprintf("%s-%s", t.checkpointBaseName, checkpointTime.Format(time.RFC3339))); err != nil {
-		log.Fatal(err)
-	}
-	buf := bufio.NewWriter(checkpointFile)
-	t.root.exportCheckpointDFS(buf)
-	t.setChange(false)
-	t.constructionNewAliasFound = false
+// prefix returns the 128-bit prefix covered by the node.
+func (n *Node) prefix() netip.Prefix {
+	return netip.PrefixFrom(n.value.mask(int(n.endPrefix)).addr(), int(n.endPrefix))
 }
 
-func (t *Radix) remove(n *Node) {
-	if n.isLeaf {
-		n.value = nil
+// familyPrefix returns the prefix covered by the node, as an IPv4 prefix if
+// it is inside ::ffff:0:0/96.
+func (n *Node) familyPrefix() netip.Prefix {
+	return familyPrefix(n.value, int(n.endPrefix))
+}
+
+// prefixString returns the prefix covered by the node in CIDR notation,
+// as an IPv4 prefix if it is inside ::ffff:0:0/96.
+func (n *Node) prefixString() string {
+	return n.familyPrefix().String()
+}
+
+// matchesBit reports whether k follows the child n at bit i, the first bit
+// of n. The default prefix leaf has no bits and is followed by every key.
+func (n *Node) matchesBit(k key, i int) bool {
+	return n.endPrefix == 0 || n.value.bit(i) == k.bit(i)
+}
+
+// hasDefaultLeaf reports whether the root n has the default prefix (::/0)
+// as an alias prefix, which is then its only child.
+func (n *Node) hasDefaultLeaf() bool {
+	return len(n.children) == 1 && n.children[0].isLeaf && n.children[0].endPrefix == 0
+}
+
+// addChild adds child under n while keeping the children ordered by the
+// first bit of the child (0 before 1), so that the tree is always traversed
+// in address order.
+func (n *Node) addChild(child *Node) {
+	if len(n.children) > 0 && child.value.bit(int(child.startPrefix)) == 0 {
+		n.children = append([]*Node{child}, n.children...)
 	} else {
-		for i := 0; i < len(n.children); i++ {
-			t.remove(n.children[i])
-			n.children[i] = nil
-		}
-		n.value = nil
-		n.children = nil
+		n.children = append(n.children, child)
 	}
 }
 
-func (t *Radix) insert(ip *net.IPNet) {
-	current := t.root
-	ones, _ := ip.Mask.Size()
+// insertLeaf adds the normalized prefix as an alias prefix with the given
+// attributes to the tree without looking for new aliases. Alias prefixes
+// under it are pruned. It returns false if the prefix was already covered by
+// an alias prefix, in which case only the attributes of an identical alias
+// prefix are updated.
+func (t *Radix) insertLeaf(prefix netip.Prefix, info *LeafInfo) bool {
+	current := t.mutableRoot()
+	ones := prefix.Bits()
+	ipKey := keyOf(prefix.Addr())
 	if current.isLeaf {
 		newNode := &Node{
+			gen:         t.gen,
 			isLeaf:      true,
 			startPrefix: 0,
 			endPrefix:   uint8(ones),
 			length:      uint8(ones),
-			value:       ip.IP.To16(),
+			value:       ipKey,
+			info:        info,
 		}
 		current.children = append(current.children, newNode)
 		current.isLeaf = false
 		newNode = nil
-		t.isChanged = true
-		return
+		t.isChanged.Store(true)
+		return true
+	} else if ones == 0 {
+		// The default prefix covers the whole tree, it replaces the
+		// children of the root.
+		if current.hasDefaultLeaf() {
+			// Identical alias prefix, it is confirmed once more.
+			leaf := t.mutableChild(current, 0)
+			if leaf.info == nil {
+				leaf.info = info
+				t.isChanged.Store(true)
+			} else if leaf.info.confirm(info.LastConfirmed, info.Tags) {
+				t.isChanged.Store(true)
+			}
+			return false
+		}
+		current.children = []*Node{{
+			gen:    t.gen,
+			isLeaf: true,
+			value:  ipKey,
+			info:   info,
+		}}
+		t.isChanged.Store(true)
+		t.constructionNewAliasFound.Store(true)
+		return true
 	} else {
-		ipBytes := ip.IP.To16()
 		ipEndPrefix := uint8(ones)
-		var i, j, k int
+		var i, j int
 		for i = 0; i < ones; {
 			matchIndex := -1
 			matchCounter := 0
@@ -224,16 +391,13 @@ func (t *Radix) insert(ip *net.IPNet) {
 					// we reach to the end already. So, we already have something here!
 					break
 				}
-				for k = i; k < int(endPrefix); k++ {
-					fb := ipBytes[k/8] & (128 >> (k % 8))
-					sb := current.children[j].value[k/8] & (128 >> (k % 8))
-					if fb != sb {
-						break
-					}
-					matchCounter++
-					matchIndex = j
+				common := current.children[j].value.commonPrefixLen(ipKey)
+				if common > int(endPrefix) {
+					common = int(endPrefix)
 				}
-				if matchIndex != -1 {
+				if common > i {
+					matchCounter = common - i
+					matchIndex = j
 					break
 				}
 			}
@@ -259,66 +423,51 @@ func (t *Radix) insert(ip *net.IPNet) {
 					// 		6. Delete the reference for the previous leaf node.
 					if i+matchCounter == int(current.children[matchIndex].endPrefix) {
 						if current.children[matchIndex].isLeaf {
-							return
+							return false
 						} else {
 							// It is not a leaf node, we have to find more in depth matches and check
 							// the children if we reached its end prefix.
-							current = current.children[matchIndex]
+							current = t.mutableChild(current, matchIndex)
 							i = i + matchCounter
 						}
 					} else if current.children[matchIndex].isLeaf {
-						if current.children[matchIndex].endPrefix == ipEndPrefix {
-							if ipEndPrefix-uint8(i+matchCounter) == 1 {
-								j := i + matchCounter
-								current.children[matchIndex].value[j/8] = current.children[matchIndex].value[j/8] & ^(128 >> (j % 8))
-								newNode := &Node{
-									isLeaf:      true,
-									startPrefix: current.children[matchIndex].startPrefix,
-									endPrefix:   uint8(i + matchCounter),
-									length:      uint8(i+matchCounter) - current.children[matchIndex].startPrefix,
-									value:       dupIP(current.children[matchIndex].value),
-								}
-								t.remove(current.children[matchIndex])
-								current.children[matchIndex] = newNode
-								newNode = nil
-								t.isChanged = true
-								t.constructionNewAliasFound = true
-								return
-							}
-						}
 						newNode := &Node{
+							gen:         t.gen,
 							isLeaf:      false,
 							startPrefix: uint8(i),
 							endPrefix:   uint8(i + matchCounter),
 							length:      uint8(matchCounter),
 							value:       current.children[matchIndex].value,
 						}
-						current.children = append(current.children, newNode)
 						newNode2 := &Node{
+							gen:         t.gen,
 							isLeaf:      true,
 							startPrefix: uint8(i + matchCounter),
 							endPrefix:   current.children[matchIndex].endPrefix,
 							length:      current.children[matchIndex].endPrefix - uint8(i+matchCounter),
 							value:       current.children[matchIndex].value,
+							info:        copyInfo(current.children[matchIndex].info),
 						}
-						newNode.children = append(newNode.children, newNode2)
+						newNode.addChild(newNode2)
 						newNode3 := &Node{
+							gen:         t.gen,
 							isLeaf:      true,
 							startPrefix: uint8(i + matchCounter),
 							endPrefix:   ipEndPrefix,
 							length:      ipEndPrefix - uint8(i+matchCounter),
-							value:       ip.IP.To16(),
+							value:       ipKey,
+							info:        info,
 						}
-						newNode.children = append(newNode.children, newNode3)
-						current.children[matchIndex] = nil
-						current.children = append(current.children[:matchIndex], current.children[matchIndex+1:]...)
+						newNode.addChild(newNode3)
+						current.children[matchIndex] = newNode
 						newNode = nil
 						newNode2 = nil
 						newNode3 = nil
-						t.isChanged = true
-						return
+						t.isChanged.Store(true)
+						return true
 					} else {
 						newNode := &Node{
+							gen:         t.gen,
 							isLeaf:      false,
 							startPrefix: uint8(i),
 							endPrefix:   uint8(i + matchCounter),
@@ -326,23 +475,26 @@ func (t *Radix) insert(ip *net.IPNet) {
 							value:       current.children[matchIndex].value,
 						}
 						newNode2 := &Node{
+							gen:         t.gen,
 							isLeaf:      true,
 							startPrefix: uint8(i + matchCounter),
 							endPrefix:   ipEndPrefix,
 							length:      ipEndPrefix - uint8(i+matchCounter),
-							value:       ip.IP.To16(),
+							value:       ipKey,
+							info:        info,
 						}
 						newNode.children = append(newNode.children, newNode2)
 
-						current.children[matchIndex].startPrefix = uint8(i + matchCounter)
-						current.children[matchIndex].length = current.children[matchIndex].endPrefix - uint8(i+matchCounter)
-						newNode.children = append(newNode.children, current.children[matchIndex])
+						child := t.mutableChild(current, matchIndex)
+						child.startPrefix = uint8(i + matchCounter)
+						child.length = child.endPrefix - uint8(i+matchCounter)
+						newNode.addChild(child)
 						current.children[matchIndex] = newNode
 
 						newNode = nil
 						newNode2 = nil
-						t.isChanged = true
-						return
+						t.isChanged.Store(true)
+						return true
 					}
 				} else {
 					// Matches all of the expected prefix, so they are identical and there is
@@ -353,46 +505,247 @@ func (t *Radix) insert(ip *net.IPNet) {
 					// 		Using input's end prefix is essential since we consumed it. It should be less
 					// 		than matched end prefix. Use matched node's start prefix as we will replace
 					// 		it with the new node.
-					// 		2. Remove the node. Apply DFS for that. DFS is required for garbage collection.
-					// 		Set all sub-tree nodes to nil (their value and children are slices).
-					// 		3. Replace the pointer of matched node to the new node. Now the matched node is
-					// 		lost, and cannot be referenced with any other material.
+					// 		2. Replace the pointer of matched node to the new node. Now the matched node is
+					// 		lost for the new version of the tree. It is left untouched since older
+					// 		versions may still be read, and it is garbage collected with them.
 					// Also if the matched node is not a leaf:
 					// In this case, their end prefix matches, but the matched node is not a leaf. Which means that, our
 					// input has higher alias prefix compared the matched node (it should go deeper).
 					if current.children[matchIndex].endPrefix != ipEndPrefix || !current.children[matchIndex].isLeaf {
 						newNode := &Node{
+							gen:         t.gen,
 							isLeaf:      true,
 							startPrefix: current.children[matchIndex].startPrefix,
 							endPrefix:   ipEndPrefix,
 							length:      ipEndPrefix - current.children[matchIndex].startPrefix,
-							value:       dupIP(ip.IP),
+							value:       ipKey,
+							info:        info,
 						}
-						t.remove(current.children[matchIndex])
 						current.children[matchIndex] = newNode
 						newNode = nil
-						t.isChanged = true
-						t.constructionNewAliasFound = true
+						t.isChanged.Store(true)
+						t.constructionNewAliasFound.Store(true)
+						return true
+					}
+					// Identical alias prefix, it is confirmed once more.
+					leaf := t.mutableChild(current, matchIndex)
+					if leaf.info == nil {
+						leaf.info = info
+						t.isChanged.Store(true)
+					} else if leaf.info.confirm(info.LastConfirmed, info.Tags) {
+						t.isChanged.Store(true)
 					}
-					return
+					return false
 				}
 			} else {
 				// No match at all, just create a new child.
 				newNode := &Node{
+					gen:         t.gen,
 					isLeaf:      true,
 					startPrefix: uint8(i),
 					endPrefix:   uint8(ones),
 					length:      uint8(ones - i),
-					value:       ip.IP.To16(),
+					value:       ipKey,
+					info:        info,
 				}
-				current.children = append(current.children, newNode)
+				current.addChild(newNode)
 				current.isLeaf = false
 				newNode = nil
-				t.isChanged = true
-				return
+				t.isChanged.Store(true)
+				return true
 			}
 		}
 	}
+	return false
+}
+
+// aggregate asks the aggregation policy whether the new alias prefix
+// allows inferring an aliased parent prefix. Inferred parents replace
+// everything under them and are fed back to the policy, so the inference
+// cascades upwards until no further parent is found. It returns the largest
+// inferred parent, which is invalid if there is none.
+func (t *Radix) aggregate(prefix netip.Prefix, seen time.Time) netip.Prefix {
+	var largest netip.Prefix
+	for {
+		parent := t.policy.Aggregate(t, prefix)
+		if !parent.IsValid() {
+			return largest
+		}
+		parent = normalizePrefix(parent)
+		if parent.Bits() >= prefix.Bits() || !parent.Contains(prefix.Addr()) {
+			log.Fatalf("aggregation policy returned %s which does not cover %s", parent, prefix)
+		}
+		info := NewLeafInfo(SourceMerge, seen, nil)
+		info.MergedFrom = t.leafPrefixesUnder(parent)
+		if !t.insertLeaf(parent, info) {
+			return largest
+		}
+		t.constructionNewAliasFound.Store(true)
+		prefix = parent
+		largest = parent
+	}
+}
+
+// leafPrefixesUnder returns the alias prefixes inside the normalized prefix.
+func (t *Radix) leafPrefixesUnder(prefix netip.Prefix) []string {
+	prefixes := []string{}
+	for _, leaf := range t.leavesUnder(prefix) {
+		prefixes = append(prefixes, leaf.prefixString())
+	}
+	return prefixes
+}
+
+// insert adds prefix as an alias prefix, looks for new aliases and returns
+// the effect of the insertion.
+func (t *Radix) insert(prefix netip.Prefix, info *LeafInfo) InsertResult {
+	prefix = normalizePrefix(prefix)
+	k := keyOf(prefix.Addr())
+	if leaf := t.lookupNode(k); leaf != nil && int(leaf.endPrefix) <= prefix.Bits() {
+		effect := InsertCovered
+		if int(leaf.endPrefix) == prefix.Bits() {
+			// confirms the identical alias prefix
+			t.insertLeaf(prefix, info)
+			effect = InsertConfirmed
+		}
+		return InsertResult{Effect: effect, Prefix: leaf.familyPrefix()}
+	}
+	pruned := len(t.leavesUnder(prefix))
+	if !t.insertLeaf(prefix, info) {
+		// nothing was added
+		effect := InsertCovered
+		if leaf := t.lookupNode(k); leaf != nil && int(leaf.endPrefix) == prefix.Bits() {
+			effect = InsertConfirmed
+		}
+		return InsertResult{Effect: effect, Prefix: familyPrefix(k, prefix.Bits())}
+	}
+	result := InsertResult{Effect: InsertNew, Prefix: familyPrefix(k, prefix.Bits()), Pruned: pruned}
+	if parent := t.aggregate(prefix, info.LastConfirmed); parent.IsValid() {
+		result.Effect = InsertMerged
+		result.Prefix = familyPrefix(keyOf(parent.Addr()), parent.Bits())
+	}
+	return result
+}
+
+// countLeaves returns the number of alias prefixes in the sub-tree of n.
+func (n *Node) countLeaves() int {
+	if n.isLeaf {
+		return 1
+	}
+	counter := 0
+	for i := 0; i < len(n.children); i++ {
+		counter += n.children[i].countLeaves()
+	}
+	return counter
+}
+
+// collapse walks the path of a node that just lost a child back to the root
+// and removes internal nodes that became redundant. The nodes on the path
+// must be modifiable. An internal node without
+// children is dropped from its parent, and an internal node with a single
+// child is replaced by that child which takes over its start prefix.
+func (t *Radix) collapse(path []*Node) {
+	for k := len(path) - 1; k > 0; k-- {
+		n := path[k]
+		parent := path[k-1]
+		if len(n.children) > 1 {
+			return
+		}
+		index := -1
+		for j := range parent.children {
+			if parent.children[j] == n {
+				index = j
+				break
+			}
+		}
+		if index == -1 {
+			log.Fatal("collapsed node is not a child of its parent")
+		}
+		if len(n.children) == 1 {
+			child := t.mutableChild(n, 0)
+			child.startPrefix = n.startPrefix
+			child.length = child.endPrefix - child.startPrefix
+			parent.children[index] = child
+			return
+		}
+		parent.children = append(parent.children[:index], parent.children[index+1:]...)
+	}
+	if root := path[0]; len(root.children) == 0 {
+		// The tree is empty again, reset the root to its initial state.
+		root.children = nil
+		root.isLeaf = true
+	}
+}
+
+// delete removes the alias prefix from the tree. Only an exact match is
+// removed unless subtree is set, in which case every alias prefix under it
+// is removed as well. It returns the number of removed alias prefixes.
+func (t *Radix) delete(prefix netip.Prefix, subtree bool) int {
+	prefix = normalizePrefix(prefix)
+	ones := prefix.Bits()
+	ipKey := keyOf(prefix.Addr())
+	if ones == 0 {
+		root := t.rootNode()
+		if len(root.children) == 0 {
+			return 0
+		}
+		if !subtree && !root.hasDefaultLeaf() {
+			// the default prefix is not an alias prefix
+			return 0
+		}
+		removed := root.countLeaves()
+		t.root.Store(&Node{isLeaf: true, gen: t.gen})
+		t.isChanged.Store(true)
+		return removed
+	}
+	current := t.mutableRoot()
+	path := []*Node{current}
+	for i := 0; i < ones; {
+		matchIndex := -1
+		for j := 0; j < len(current.children); j++ {
+			if i != int(current.children[j].startPrefix) {
+				log.Fatal("start Prefix don't match with current bit index")
+			}
+			if current.children[j].matchesBit(ipKey, i) {
+				matchIndex = j
+				break
+			}
+		}
+		if matchIndex == -1 {
+			return 0
+		}
+		child := current.children[matchIndex]
+		endPrefix := int(child.endPrefix)
+		if endPrefix > ones {
+			endPrefix = ones
+		}
+		if child.value.commonPrefixLen(ipKey) < endPrefix {
+			return 0
+		}
+		if int(child.endPrefix) < ones {
+			if child.isLeaf {
+				// The prefix is inside a shorter alias prefix, there is nothing
+				// to remove at this exact position.
+				return 0
+			}
+			current = t.mutableChild(current, matchIndex)
+			path = append(path, current)
+			i = int(child.endPrefix)
+			continue
+		}
+		var removed int
+		if int(child.endPrefix) == ones && child.isLeaf {
+			removed = 1
+		} else if subtree {
+			removed = child.countLeaves()
+		} else {
+			return 0
+		}
+		current.children = append(current.children[:matchIndex], current.children[matchIndex+1:]...)
+		t.collapse(path)
+		t.isChanged.Store(true)
+		return removed
+	}
+	return 0
 }
 
 func (t *Radix) setCheckpointFrequency(checkpointFrequency float32) {
@@ -403,27 +756,81 @@ func (t *Radix) setCheckpointBaseName(checkpointBaseName string) {
 	t.checkpointBaseName = checkpointBaseName
 }
 
+func (t *Radix) setAggregationPolicy(policy AggregationPolicy) {
+	t.policy = policy
+}
+
 func (t *Radix) setChange(val bool) {
-	t.isChanged = val
+	t.isChanged.Store(val)
 }
 
 func (n *Node) String() string {
 	return fmt.Sprintf("{Mem-Location: %p, Value: %s/%d-%d, Length: %d, isLeaf: %t, Num-Children: %d}",
-		n, n.value, n.startPrefix, n.endPrefix, n.length, n.isLeaf, len(n.children))
+		n, n.value.addr(), n.startPrefix, n.endPrefix, n.length, n.isLeaf, len(n.children))
 }
 
 func InitRadix() *Radix {
-	return &Radix{
-		root: &Node{
-			isLeaf:      true,
-			startPrefix: 0,
-			endPrefix:   0,
-		},
-		isChanged:                 false,
-		constructionNewAliasFound: false,
-		checkpointBaseName:        "checkpoint",
-		checkpointFrequency:       1.0,
+	t := &Radix{
+		gen:                 generation.Add(1),
+		policy:              &SiblingPolicy{},
+		checkpointBaseName:  "checkpoint",
+		checkpointFrequency: 1.0,
+		checkpointFormat:    FormatText,
+	}
+	t.root.Store(&Node{
+		isLeaf:      true,
+		startPrefix: 0,
+		endPrefix:   0,
+		gen:         t.gen,
+	})
+	return t
+}
+
+// SetFrontTable puts a direct-indexed table in front of the tree, indexed
+// with the given number of leading bits (between 1 and MaxFrontTableBits),
+// or removes it if bits is 0. Lookups start from the entry of the table
+// instead of the root, which saves the traversal of the top of the tree when
+// the alias prefixes are longer than the indexed bits. The table holds
+// 2^bits entries and is updated on each modification of the tree, so it is
+// best set once the tree is constructed.
+func (t *Radix) SetFrontTable(bits int) {
+	t.writer.Lock()
+	defer t.writer.Unlock()
+	if bits == 0 {
+		t.front.Store(nil)
+		return
+	}
+	t.front.Store(newFrontTable(bits, t.rootNode()))
+}
+
+// Snapshot returns a view of the tree as of now, with the same settings.
+// Later modifications of the tree do not affect the snapshot and the other
+// way around, so it can be read or exported while the tree is modified.
+func (t *Radix) Snapshot() *Radix {
+	snapshot := &Radix{
+		policy:              t.policy,
+		checkpointBaseName:  t.checkpointBaseName,
+		checkpointFrequency: t.checkpointFrequency,
+		checkpointKeep:      t.checkpointKeep,
+		checkpointFormat:    t.checkpointFormat,
+		checkpointMaxAge:    t.checkpointMaxAge,
 	}
+	root := t.rootNode()
+	if table := t.front.Load(); table != nil {
+		// The root of the table may be older than the root of the tree
+		// during a modification, the snapshot uses the same version for both.
+		snapshot.front.Store(table)
+		root = table.root
+	}
+	snapshot.root.Store(root)
+	snapshot.isChanged.Store(t.isChanged.Load())
+	snapshot.constructionNewAliasFound.Store(t.constructionNewAliasFound.Load())
+	return snapshot
+}
+
+// View implements Store with a snapshot of the tree.
+func (t *Radix) View() Store {
+	return t.Snapshot()
 }
 
 func (t *Radix) SetCheckpointFrequency(checkpointFrequency float32) {
@@ -434,36 +841,121 @@ func (t *Radix) SetCheckpointBaseName(checkpointBaseName string) {
 	t.setCheckpointBaseName(checkpointBaseName)
 }
 
+// SetAggregationPolicy sets the policy used to infer new aliased prefixes
+// on insertion. The default policy is SiblingPolicy.
+func (t *Radix) SetAggregationPolicy(policy AggregationPolicy) {
+	t.setAggregationPolicy(policy)
+}
+
 func (t *Radix) SetChange(val bool) {
 	t.setChange(val)
 }
 
+// SetCheckpointRetention sets how many checkpoints are kept after a new one
+// is exported and how old they can get. Zero values disable the respective
+// limit.
+func (t *Radix) SetCheckpointRetention(keep int, maxAge time.Duration) {
+	t.checkpointKeep = keep
+	t.checkpointMaxAge = maxAge
+}
+
+// SetCheckpointFormat sets the format of the exported checkpoints, either
+// FormatText or FormatBinary.
+func (t *Radix) SetCheckpointFormat(format string) {
+	t.checkpointFormat = format
+}
+
 func (t *Radix) GetCheckpointFrequency() float32 {
 	return t.checkpointFrequency
 }
 
 func (t *Radix) CheckConstructionNewAliasFound() bool {
-	return t.constructionNewAliasFound
+	return t.constructionNewAliasFound.Load()
 }
 
 func (t *Radix) IsChanged() bool {
-	return t.isChanged
+	return t.isChanged.Load()
+}
+
+// Count returns the number of alias prefixes in the tree.
+func (t *Radix) Count() int {
+	root := t.rootNode()
+	if root.isLeaf {
+		return 0
+	}
+	return root.countLeaves()
 }
 
 func (t *Radix) TraverseBFSRadix() {
 	t.traverseBFSRadix()
 }
 
-func (t *Radix) Remove(n *Node) {
-	t.remove(n)
+// LookUp returns the label of the alias prefix containing addr.
+func (t *Radix) LookUp(addr netip.Addr) Label {
+	return t.lookup(addr)
+}
+
+// LookUpMatch returns the alias prefix containing addr and whether there is
+// one. It does not allocate.
+func (t *Radix) LookUpMatch(addr netip.Addr) (Match, bool) {
+	leaf := t.lookupNode(keyOf(addr))
+	if leaf == nil {
+		return Match{}, false
+	}
+	return Match{Prefix: leaf.familyPrefix(), Info: leaf.info}, true
+}
+
+// LookUpBatch returns the labels of the alias prefixes containing each of
+// addrs, in the same order. The addresses are looked up on the same version
+// of the tree, and the traversal is shared between consecutive addresses, so
+// sorted batches are faster to look up than the same addresses one by one.
+// Consecutive addresses in the same alias prefix share the attributes of
+// their labels.
+func (t *Radix) LookUpBatch(addrs []netip.Addr) []Label {
+	return t.lookupBatch(addrs)
+}
+
+// IsAliased reports whether the whole prefix is inside an alias prefix.
+func (t *Radix) IsAliased(prefix netip.Prefix) bool {
+	prefix = normalizePrefix(prefix)
+	leaf := t.lookupNode(keyOf(prefix.Addr()))
+	return leaf != nil && int(leaf.endPrefix) <= prefix.Bits()
+}
+
+// Insert adds prefix as an alias prefix received with an insert command.
+func (t *Radix) Insert(prefix netip.Prefix) {
+	t.InsertWithInfo(prefix, NewLeafInfo(SourceInsert, time.Now(), nil))
+}
+
+// InsertWithInfo adds prefix as an alias prefix with the given attributes.
+// If prefix is already an alias prefix, its last confirmation time and tags
+// are updated instead. It returns the effect of the insertion.
+func (t *Radix) InsertWithInfo(prefix netip.Prefix, info *LeafInfo) InsertResult {
+	var result InsertResult
+	t.update(func(draft *Radix) {
+		result = draft.insert(prefix, info)
+	})
+	return result
 }
 
-func (t *Radix) LookUp(ip net.IP) Label {
-	return t.lookup(ip)
+// Delete removes the exact alias prefix from the tree and reports whether
+// it was present.
+func (t *Radix) Delete(prefix netip.Prefix) bool {
+	removed := 0
+	t.update(func(draft *Radix) {
+		removed = draft.delete(prefix, false)
+	})
+	return removed == 1
 }
 
-func (t *Radix) Insert(ip *net.IPNet) {
-	t.insert(ip)
+// DeleteSubtree removes prefix and every alias prefix under it. It returns
+// the number of removed alias prefixes.
+func (t *Radix) DeleteSubtree(prefix netip.Prefix) int {
+	removed := 0
+	t.update(func(draft *Radix) {
+		removed = draft.delete(prefix, true)
+	})
+	return removed
 }
 
 func Tester(prefixFile, testInput string, stepSize int) {
@@ -478,13 +970,8 @@ func Tester(prefixFile, testInput string, stepSize int) {
 	radix := InitRadix()
 
 	for scanner.Scan() {
-		parsedIP, parsedNetwork, err := net.ParseCIDR(scanner.Text())
+		parsedNetwork, err := netip.ParsePrefix(scanner.Text())
 		check(err)
-		if parsedIP == nil || parsedNetwork == nil {
-			fmt.Println(scanner.Text())
-			fmt.Println(counter)
-			continue
-		}
 		radix.Insert(parsedNetwork)
 		counter = counter + 1
 		if counter%stepSize == 0 {
@@ -501,8 +988,8 @@ func Tester(prefixFile, testInput string, stepSize int) {
 	scanner.Split(bufio.ScanLines)
 
 	for scanner.Scan() {
-		parsedIP := net.ParseIP(scanner.Text())
-		if parsedIP == nil {
+		parsedIP, err := netip.ParseAddr(scanner.Text())
+		if err != nil {
 			continue
 		}
 		fmt.Printf("Lookup for: %s -> %v\n", parsedIP, radix.LookUp(parsedIP))
@@ -0,0 +1,1324 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package radix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func parseCIDR(t *testing.T, s string) netip.Prefix {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		t.Fatalf("cannot parse %s: %v", s, err)
+	}
+	return prefix.Masked()
+}
+
+func (n *Node) collectLeaves(leaves []string) []string {
+	if n.isLeaf {
+		return append(leaves, n.prefixString())
+	}
+	for i := range n.children {
+		leaves = n.children[i].collectLeaves(leaves)
+	}
+	return leaves
+}
+
+func leaves(tree *Radix) []string {
+	if tree.rootNode().isLeaf {
+		return []string{}
+	}
+	result := tree.rootNode().collectLeaves([]string{})
+	sort.Strings(result)
+	return result
+}
+
+// checkStructure verifies that every non-root internal node has at least two
+// children and that the start prefixes line up with the parent end prefixes.
+func checkStructure(t *testing.T, n *Node, isRoot bool) {
+	if n.isLeaf {
+		return
+	}
+	if !isRoot && len(n.children) < 2 {
+		t.Errorf("redundant internal node %v", n)
+	}
+	for _, child := range n.children {
+		if child.startPrefix != n.endPrefix {
+			t.Errorf("child %v does not start at the end of its parent %v", child, n)
+		}
+		if child.length != child.endPrefix-child.startPrefix {
+			t.Errorf("wrong length for %v", child)
+		}
+		checkStructure(t, child, false)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	for _, tt := range []struct {
+		nodes     []string
+		delete    string
+		recursive bool
+		removed   int
+		expected  []string
+	}{
+		{
+			nodes:    []string{"2001:db8::/32", "2001:db9:1::/48"},
+			delete:   "2001:db8::/32",
+			removed:  1,
+			expected: []string{"2001:db9:1::/48"},
+		},
+		{
+			nodes:    []string{"2001:db8::/32", "2001:db9:1::/48"},
+			delete:   "2001:db8::/48",
+			removed:  0,
+			expected: []string{"2001:db8::/32", "2001:db9:1::/48"},
+		},
+		{
+			nodes:    []string{"2001:db8:1::/48", "2001:db8:2::/48", "2001:db8:4::/48"},
+			delete:   "2001:db8:2::/48",
+			removed:  1,
+			expected: []string{"2001:db8:1::/48", "2001:db8:4::/48"},
+		},
+		{
+			nodes:    []string{"2001:db8:1::/48", "2001:db8:2::/48", "2001:db8:4::/48", "3001::/16"},
+			delete:   "2001:db8::/32",
+			removed:  0,
+			expected: []string{"2001:db8:1::/48", "2001:db8:2::/48", "2001:db8:4::/48", "3001::/16"},
+		},
+		{
+			nodes:     []string{"2001:db8:1::/48", "2001:db8:2::/48", "2001:db8:4::/48", "3001::/16"},
+			delete:    "2001:db8::/32",
+			recursive: true,
+			removed:   3,
+			expected:  []string{"3001::/16"},
+		},
+		{
+			nodes:     []string{"2001:db8:1::/48", "3001::/16"},
+			delete:    "::/0",
+			recursive: true,
+			removed:   2,
+			expected:  []string{},
+		},
+		{
+			nodes:    []string{"2001:db8:1::/48"},
+			delete:   "2001:db8:1::/48",
+			removed:  1,
+			expected: []string{},
+		},
+	} {
+		tree := InitRadix()
+		for _, prefix := range tt.nodes {
+			tree.Insert(parseCIDR(t, prefix))
+		}
+		tree.SetChange(false)
+		var removed int
+		if tt.recursive {
+			removed = tree.DeleteSubtree(parseCIDR(t, tt.delete))
+		} else if tree.Delete(parseCIDR(t, tt.delete)) {
+			removed = 1
+		}
+		if removed != tt.removed {
+			t.Errorf("wrong number of deleted prefixes for %s: given: %d - expected: %d", tt.delete, removed, tt.removed)
+		}
+		if tree.IsChanged() != (tt.removed > 0) {
+			t.Errorf("wrong change flag after deleting %s", tt.delete)
+		}
+		if got := leaves(tree); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong prefixes after deleting %s: given: %v - expected: %v", tt.delete, got, tt.expected)
+		}
+		checkStructure(t, tree.rootNode(), true)
+		for _, prefix := range tt.expected {
+			if label := tree.LookUp(parseCIDR(t, prefix).Addr()); !label.Aliased {
+				t.Errorf("%s should still be aliased after deleting %s", prefix, tt.delete)
+			}
+		}
+		if label := tree.LookUp(parseCIDR(t, tt.delete).Addr()); tt.removed > 0 && label.Aliased {
+			t.Errorf("%s should not be aliased after deletion", tt.delete)
+		}
+	}
+}
+
+func TestDeleteThenInsert(t *testing.T) {
+	tree := InitRadix()
+	tree.Insert(parseCIDR(t, "2001:db8:1::/48"))
+	tree.Delete(parseCIDR(t, "2001:db8:1::/48"))
+	tree.Insert(parseCIDR(t, "2001:db8:2::/48"))
+	if got := leaves(tree); !reflect.DeepEqual(got, []string{"2001:db8:2::/48"}) {
+		t.Errorf("wrong prefixes after re-insertion: %v", got)
+	}
+	if label := tree.LookUp(netip.MustParseAddr("2001:db8:2::1")); !label.Aliased {
+		t.Errorf("re-inserted prefix should be aliased")
+	}
+}
+
+func readCheckpoint(t *testing.T, tree *Radix, dir, name string) string {
+	tree.SetCheckpointBaseName(filepath.Join(dir, name))
+	checkpointTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := tree.ExportCheckpoint(checkpointTime); err != nil {
+		t.Fatalf("cannot export checkpoint: %v", err)
+	}
+	content, err := os.ReadFile(fmt.Sprintf("%s-%s", filepath.Join(dir, name), checkpointTime.Format(time.RFC3339)))
+	if err != nil {
+		t.Fatalf("cannot read checkpoint: %v", err)
+	}
+	return string(content)
+}
+
+func TestCascadingMerge(t *testing.T) {
+	prefixes := []string{
+		"2001:db8::/48",
+		"2001:db8:1::/48",
+		"2001:db8:2::/48",
+		"2001:db8:3::/48",
+		"2001:db8:2:1::/64",
+		"2001:db8:4::/47",
+		"2001:db8:6::/48",
+		"2001:db8:7::/48",
+		"2001:db8:8::/48",
+		"2001:db8:a::/47",
+		"3001::/16",
+		"3000::/16",
+		"3002::/17",
+	}
+	seen := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expected := strings.Join([]string{
+		"2001:db8::/45\t{\"source\":\"merge\",\"first_seen\":\"2024-01-01T00:00:00Z\",\"last_confirmed\":\"2024-01-01T00:00:00Z\",\"merged_from\":[\"2001:db8::/46\",\"2001:db8:4::/46\"]}",
+		"2001:db8:8::/48\t{\"source\":\"insert\",\"first_seen\":\"2024-01-01T00:00:00Z\",\"last_confirmed\":\"2024-01-01T00:00:00Z\"}",
+		"2001:db8:a::/47\t{\"source\":\"insert\",\"first_seen\":\"2024-01-01T00:00:00Z\",\"last_confirmed\":\"2024-01-01T00:00:00Z\"}",
+		"3000::/15\t{\"source\":\"merge\",\"first_seen\":\"2024-01-01T00:00:00Z\",\"last_confirmed\":\"2024-01-01T00:00:00Z\",\"merged_from\":[\"3000::/16\",\"3001::/16\"]}",
+		"3002::/17\t{\"source\":\"insert\",\"first_seen\":\"2024-01-01T00:00:00Z\",\"last_confirmed\":\"2024-01-01T00:00:00Z\"}",
+		"",
+	}, "\n")
+	dir := t.TempDir()
+	rng := rand.New(rand.NewSource(42))
+	for round := 0; round < 50; round++ {
+		shuffled := make([]string, len(prefixes))
+		copy(shuffled, prefixes)
+		rng.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		tree := InitRadix()
+		for _, prefix := range shuffled {
+			tree.InsertWithInfo(parseCIDR(t, prefix), NewLeafInfo(SourceInsert, seen, nil))
+		}
+		if !tree.CheckConstructionNewAliasFound() {
+			t.Errorf("new aliases should be found for order %v", shuffled)
+		}
+		checkStructure(t, tree.rootNode(), true)
+		if checkpoint := readCheckpoint(t, tree, dir, fmt.Sprintf("round%d", round)); checkpoint != expected {
+			t.Fatalf("checkpoint does not match for order %v: given:\n%s\nexpected:\n%s", shuffled, checkpoint, expected)
+		}
+	}
+}
+
+func TestInsertResult(t *testing.T) {
+	tree := InitRadix()
+	seen := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		prefix   string
+		expected InsertResult
+	}{
+		{"2001:db8::/48", InsertResult{Effect: InsertNew, Prefix: parseCIDR(t, "2001:db8::/48")}},
+		{"2001:db8::/48", InsertResult{Effect: InsertConfirmed, Prefix: parseCIDR(t, "2001:db8::/48")}},
+		{"2001:db8:0:1::/64", InsertResult{Effect: InsertCovered, Prefix: parseCIDR(t, "2001:db8::/48")}},
+		{"2001:db8:2:1::/64", InsertResult{Effect: InsertNew, Prefix: parseCIDR(t, "2001:db8:2:1::/64")}},
+		{"2001:db8:2:2::/64", InsertResult{Effect: InsertNew, Prefix: parseCIDR(t, "2001:db8:2:2::/64")}},
+		{"2001:db8:2::/48", InsertResult{Effect: InsertNew, Prefix: parseCIDR(t, "2001:db8:2::/48"), Pruned: 2}},
+		{"2001:db8:3::/48", InsertResult{Effect: InsertMerged, Prefix: parseCIDR(t, "2001:db8:2::/47")}},
+		{"2001:db8:1::/48", InsertResult{Effect: InsertMerged, Prefix: parseCIDR(t, "2001:db8::/46")}},
+		{"192.0.2.0/25", InsertResult{Effect: InsertNew, Prefix: parseCIDR(t, "192.0.2.0/25")}},
+		{"192.0.2.128/25", InsertResult{Effect: InsertMerged, Prefix: parseCIDR(t, "192.0.2.0/24")}},
+		{"192.0.2.7/32", InsertResult{Effect: InsertCovered, Prefix: parseCIDR(t, "192.0.2.0/24")}},
+	} {
+		if given := tree.InsertWithInfo(parseCIDR(t, tt.prefix), NewLeafInfo(SourceInsert, seen, nil)); given != tt.expected {
+			t.Errorf("insert %s: given %+v, expected %+v", tt.prefix, given, tt.expected)
+		}
+	}
+}
+
+func TestAggregationPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy   AggregationPolicy
+		nodes    []string
+		expected []string
+	}{
+		{
+			policy:   &SiblingPolicy{MinLength: 32},
+			nodes:    []string{"2001:db8::/33", "2001:db8:8000::/33", "2001:db9::/32"},
+			expected: []string{"2001:db8::/32", "2001:db9::/32"},
+		},
+		{
+			policy:   &SiblingPolicy{MinLength: 32},
+			nodes:    []string{"2001:db8::/33", "2001:db8:8000::/33", "2001:db9::/32", "2001:dba::/32"},
+			expected: []string{"2001:db8::/32", "2001:db9::/32", "2001:dba::/32"},
+		},
+		{
+			policy:   &NibblePolicy{Threshold: 3},
+			nodes:    []string{"2001:db8:1::/48", "2001:db8:5::/48"},
+			expected: []string{"2001:db8:1::/48", "2001:db8:5::/48"},
+		},
+		{
+			policy:   &NibblePolicy{Threshold: 3},
+			nodes:    []string{"2001:db8:1::/48", "2001:db8:5::/48", "2001:db8:f::/48"},
+			expected: []string{"2001:db8::/44"},
+		},
+		{
+			policy:   &NibblePolicy{Threshold: 3, MinLength: 48},
+			nodes:    []string{"2001:db8:1::/48", "2001:db8:5::/48", "2001:db8:f::/48"},
+			expected: []string{"2001:db8:1::/48", "2001:db8:5::/48", "2001:db8:f::/48"},
+		},
+		{
+			policy:   &NibblePolicy{Threshold: 1},
+			nodes:    []string{"2001:db8::/47"},
+			expected: []string{"2001:db8::/47"},
+		},
+	} {
+		tree := InitRadix()
+		tree.SetAggregationPolicy(tt.policy)
+		for _, prefix := range tt.nodes {
+			tree.Insert(parseCIDR(t, prefix))
+		}
+		if got := leaves(tree); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong prefixes with %+v: given: %v - expected: %v", tt.policy, got, tt.expected)
+		}
+		checkStructure(t, tree.rootNode(), true)
+	}
+}
+
+func TestLeafInfo(t *testing.T) {
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	tree := InitRadix()
+	tree.InsertWithInfo(parseCIDR(t, "2001:db8::/32"), NewLeafInfo(SourceConstruct, first, map[string]string{"dataset": "6sense"}))
+	tree.SetChange(false)
+	tree.InsertWithInfo(parseCIDR(t, "2001:db8::/32"), NewLeafInfo(SourceInsert, second, map[string]string{"vantage": "us"}))
+	if !tree.IsChanged() {
+		t.Errorf("confirming an alias prefix should change the tree")
+	}
+	label := tree.LookUp(netip.MustParseAddr("2001:db8::1"))
+	if label.Info == nil {
+		t.Fatalf("lookup should return the attributes of the alias prefix")
+	}
+	expected := LeafInfo{
+		Source:        SourceConstruct,
+		FirstSeen:     first,
+		LastConfirmed: second,
+		Tags:          map[string]string{"dataset": "6sense", "vantage": "us"},
+	}
+	if !reflect.DeepEqual(*label.Info, expected) {
+		t.Errorf("wrong attributes: given: %+v - expected: %+v", *label.Info, expected)
+	}
+
+	line, err := FormatCheckpointLine(parseCIDR(t, label.Metadata), label.Info)
+	if err != nil {
+		t.Fatalf("cannot format checkpoint line: %v", err)
+	}
+	prefix, info, err := ParseCheckpointLine(line)
+	if err != nil {
+		t.Fatalf("cannot parse checkpoint line %s: %v", line, err)
+	}
+	if prefix.String() != "2001:db8::/32" || !reflect.DeepEqual(*info, expected) {
+		t.Errorf("checkpoint line does not round trip: %s", line)
+	}
+	if prefix, info, err = ParseCheckpointLine("2001:db8::/32"); err != nil || prefix.String() != "2001:db8::/32" || info != nil {
+		t.Errorf("plain prefix lines should be parsed without attributes")
+	}
+}
+
+func TestLongCheckpointLine(t *testing.T) {
+	// Attributes longer than the buffer of the reader are read whole, and
+	// so is a last line without a newline.
+	dir := t.TempDir()
+	tree := InitRadix()
+	tree.InsertWithInfo(parseCIDR(t, "2001:db8::/32"), NewLeafInfo(SourceInsert, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), map[string]string{"notes": strings.Repeat("x", 70000)}))
+	checkpoint := readCheckpoint(t, tree, dir, "long")
+	restored := InitRadix()
+	if imported, err := restored.ImportPrefixes(strings.NewReader(strings.TrimSuffix(checkpoint, "\n")), SourceConstruct); err != nil || imported != 1 {
+		t.Fatalf("cannot import a checkpoint with a long line: %d, %v", imported, err)
+	}
+	if readCheckpoint(t, restored, dir, "restored") != checkpoint {
+		t.Errorf("checkpoint with a long line does not round trip")
+	}
+}
+
+func TestFindLatestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	baseName := filepath.Join(dir, "checkpoint")
+	if checkpointFile, _, err := FindLatestCheckpoint(baseName); err != nil || checkpointFile != "" {
+		t.Fatalf("no checkpoint should be found in an empty directory: %s, %v", checkpointFile, err)
+	}
+	for _, name := range []string{
+		"checkpoint-2024-01-01T00:00:00Z",
+		"checkpoint-2024-03-01T00:00:00+01:00",
+		"checkpoint-2024-02-01T00:00:00Z",
+		"checkpoint-garbage",
+		"other-2025-01-01T00:00:00Z",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("2001:db8::/32\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkpointFile, checkpointTime, err := FindLatestCheckpoint(baseName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpointFile != filepath.Join(dir, "checkpoint-2024-03-01T00:00:00+01:00") {
+		t.Errorf("wrong checkpoint: %s", checkpointFile)
+	}
+	if !checkpointTime.Equal(time.Date(2024, 2, 29, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("wrong checkpoint time: %s", checkpointTime)
+	}
+	tree := InitRadix()
+	if imported, err := tree.ImportPrefixFile(checkpointFile, SourceConstruct); err != nil || imported != 1 {
+		t.Errorf("cannot import checkpoint: %d, %v", imported, err)
+	}
+}
+
+func TestCheckpointRetention(t *testing.T) {
+	dir := t.TempDir()
+	baseName := filepath.Join(dir, "checkpoint")
+	// A leftover of a checkpoint which was never completed.
+	if err := os.WriteFile(filepath.Join(dir, ".checkpoint-123.tmp"), []byte("2001:db8::/3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tree := InitRadix()
+	tree.SetCheckpointBaseName(baseName)
+	tree.SetCheckpointRetention(2, 0)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		tree.Insert(parseCIDR(t, fmt.Sprintf("2001:db8:%x::/48", 2*i)))
+		if err := tree.ExportCheckpoint(start.Add(time.Duration(i) * time.Minute)); err != nil {
+			t.Fatalf("cannot export checkpoint: %v", err)
+		}
+		if tree.IsChanged() {
+			t.Errorf("tree should not be changed after a checkpoint")
+		}
+	}
+	checkpoints, err := listCheckpoints(baseName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 2 || !checkpoints[0].time.Equal(start.Add(3*time.Minute)) || !checkpoints[1].time.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("wrong checkpoints are kept: %v", checkpoints)
+	}
+	target, err := os.Readlink(baseName + "-latest")
+	if err != nil {
+		t.Fatalf("cannot read the latest link: %v", err)
+	}
+	if target != filepath.Base(checkpoints[0].path) {
+		t.Errorf("latest link points to %s instead of %s", target, checkpoints[0].path)
+	}
+	latest, _, err := FindLatestCheckpoint(baseName)
+	if err != nil || latest != checkpoints[0].path {
+		t.Errorf("temporary files or links should not be found as checkpoints: %s, %v", latest, err)
+	}
+
+	tree.SetCheckpointRetention(0, 90*time.Second)
+	tree.Insert(parseCIDR(t, "2001:db8:10::/48"))
+	if err := tree.ExportCheckpoint(start.Add(4 * time.Minute)); err != nil {
+		t.Fatalf("cannot export checkpoint: %v", err)
+	}
+	if checkpoints, _ = listCheckpoints(baseName); len(checkpoints) != 2 {
+		t.Errorf("checkpoints older than the max age should be removed: %v", checkpoints)
+	}
+
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0700)
+	tree.Insert(parseCIDR(t, "2001:db8:20::/48"))
+	if err := tree.ExportCheckpoint(start.Add(5 * time.Minute)); err == nil && os.Geteuid() != 0 {
+		t.Errorf("exporting to a read-only directory should fail")
+	} else if err != nil && !tree.IsChanged() {
+		t.Errorf("tree should stay changed if the checkpoint fails")
+	}
+}
+
+func randomTree(rng *rand.Rand, size int) *Radix {
+	tree := InitRadix()
+	seen := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < size; i++ {
+		var raw [16]byte
+		rng.Read(raw[:])
+		prefix := netip.PrefixFrom(netip.AddrFrom16(raw), 32+rng.Intn(33)).Masked()
+		tree.InsertWithInfo(prefix, NewLeafInfo(SourceInsert, seen, nil))
+	}
+	return tree
+}
+
+func TestBinaryCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	tree := randomTree(rand.New(rand.NewSource(7)), 1000)
+	tree.InsertWithInfo(parseCIDR(t, "3001::/16"), NewLeafInfo(SourceConstruct, time.Date(2024, 1, 1, 0, 0, 0, 5, time.UTC), map[string]string{"dataset": "6sense", "vantage": "us"}))
+	tree.Insert(parseCIDR(t, "3000::/16"))
+	expected := readCheckpoint(t, tree, dir, "text")
+
+	tree.SetCheckpointFormat(FormatBinary)
+	tree.SetCheckpointBaseName(filepath.Join(dir, "binary"))
+	checkpointTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := tree.ExportCheckpoint(checkpointTime); err != nil {
+		t.Fatal(err)
+	}
+	binaryFile := checkpointName(filepath.Join(dir, "binary"), checkpointTime)
+	content, err := os.ReadFile(binaryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := ReadBinaryCheckpointHeader(strings.NewReader(string(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != binaryVersion || !header.CreatedAt.Equal(checkpointTime) || header.Count != uint64(tree.rootNode().countLeaves()) || header.ToolVersion != ToolVersion {
+		t.Errorf("wrong header: %+v", header)
+	}
+
+	restored := InitRadix()
+	if imported, err := restored.ImportPrefixFile(binaryFile, SourceConstruct); err != nil || uint64(imported) != header.Count {
+		t.Fatalf("cannot import binary checkpoint: %d, %v", imported, err)
+	}
+	if checkpoint := readCheckpoint(t, restored, dir, "restored"); checkpoint != expected {
+		t.Errorf("binary checkpoint does not round trip")
+	}
+
+	corruptions := map[string][]byte{
+		"flipped":   append(append([]byte{}, content[:100]...), append([]byte{content[100] ^ 1}, content[101:]...)...),
+		"truncated": content[:len(content)/2],
+		"trailer":   content[:len(content)-1],
+	}
+	// The prefix count follows the magic, the version and the creation
+	// time. A corrupted count is detected, whichever byte is flipped.
+	const countOffset = len(binaryMagic) + 2 + 8
+	for i := 0; i < 8; i++ {
+		corrupted := append([]byte{}, content...)
+		corrupted[countOffset+i] ^= 0xFF
+		corruptions[fmt.Sprintf("count byte %d", i)] = corrupted
+	}
+	for name, corrupted := range corruptions {
+		restored := InitRadix()
+		imported, err := restored.ImportPrefixes(strings.NewReader(string(corrupted)), SourceConstruct)
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("%s checkpoint should fail the checksum: %v", name, err)
+		}
+		if imported != 0 || !restored.rootNode().isLeaf {
+			t.Errorf("nothing should be imported from a %s checkpoint", name)
+		}
+	}
+}
+
+func TestBinaryCheckpointLargeAttributes(t *testing.T) {
+	// Counts and lengths beyond 16 bits, as reached by cascading merges,
+	// round trip.
+	dir := t.TempDir()
+	tree := InitRadix()
+	info := NewLeafInfo(SourceInsert, time.Date(2024, 1, 1, 0, 0, 0, 5, time.UTC), map[string]string{"notes": strings.Repeat("x", 70000)})
+	for i := 0; i < 70000; i++ {
+		info.MergedFrom = append(info.MergedFrom, fmt.Sprintf("2001:db8:%x::/48", i))
+	}
+	tree.InsertWithInfo(parseCIDR(t, "2001:db8::/32"), info)
+	expected := readCheckpoint(t, tree, dir, "text")
+
+	tree.SetCheckpointFormat(FormatBinary)
+	content := readCheckpoint(t, tree, dir, "binary")
+	restored := InitRadix()
+	if _, err := restored.ImportPrefixes(strings.NewReader(content), SourceConstruct); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint := readCheckpoint(t, restored, dir, "restored"); checkpoint != expected {
+		t.Errorf("attributes do not round trip")
+	}
+}
+
+func TestLoadBinary(t *testing.T) {
+	dir := t.TempDir()
+	rng := rand.New(rand.NewSource(7))
+	for _, tt := range []struct {
+		name string
+		// prefixes are the alias prefixes of the tree, a random tree if nil.
+		prefixes []string
+	}{
+		{"random", nil},
+		{"default", []string{"::/0"}},
+		{"first bit", []string{"2001:db8::/32", "a001::/16", "192.0.2.0/24"}},
+		{"single", []string{"2001:db8::/128"}},
+	} {
+		name := tt.name
+		tree := randomTree(rng, 1000)
+		if tt.prefixes != nil {
+			tree = InitRadix()
+			for _, prefix := range tt.prefixes {
+				tree.Insert(parseCIDR(t, prefix))
+			}
+		}
+		tree.SetCheckpointFormat(FormatBinary)
+		loaded := InitRadix()
+		if _, err := loaded.ImportPrefixes(strings.NewReader(readCheckpoint(t, tree, dir, name)), SourceConstruct); err != nil {
+			t.Fatal(err)
+		}
+		checkStructure(t, loaded.rootNode(), true)
+		if !reflect.DeepEqual(leaves(loaded), leaves(tree)) {
+			t.Errorf("%s: wrong alias prefixes %v, expected %v", name, leaves(loaded), leaves(tree))
+		}
+		for _, addr := range lookUpAddresses(rng, tree, 1000) {
+			if label, expected := loaded.LookUp(addr), tree.LookUp(addr); label.Aliased != expected.Aliased || label.Metadata != expected.Metadata {
+				t.Errorf("%s: wrong lookup of %s: %+v, expected %+v", name, addr, label, expected)
+			}
+		}
+		// the loaded tree is modified like the original one
+		for i := 0; i < 100; i++ {
+			var raw [16]byte
+			rng.Read(raw[:])
+			prefix := netip.PrefixFrom(netip.AddrFrom16(raw), 1+rng.Intn(64)).Masked()
+			tree.Insert(prefix)
+			loaded.Insert(prefix)
+		}
+		if !reflect.DeepEqual(leaves(loaded), leaves(tree)) {
+			t.Errorf("%s: the loaded tree is modified differently", name)
+		}
+	}
+
+	// A checkpoint which is not sorted or has overlapping prefixes is
+	// inserted prefix by prefix.
+	var buf bytes.Buffer
+	if err := writeBinary(&buf, time.Now(), 2, func(fn func(prefix netip.Prefix, info *LeafInfo) bool) {
+		fn(parseCIDR(t, "2001:db8::/48"), nil)
+		fn(parseCIDR(t, "2001:db8::/32"), nil)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	loaded := InitRadix()
+	if _, err := loaded.ImportPrefixes(&buf, SourceConstruct); err != nil {
+		t.Fatal(err)
+	}
+	if prefixes := leaves(loaded); !reflect.DeepEqual(prefixes, []string{"2001:db8::/32"}) {
+		t.Errorf("wrong alias prefixes %v", prefixes)
+	}
+}
+
+func benchmarkImport(b *testing.B, format string) {
+	tree := randomTree(rand.New(rand.NewSource(7)), 100000)
+	tree.SetCheckpointFormat(format)
+	tree.SetCheckpointBaseName(filepath.Join(b.TempDir(), "checkpoint"))
+	checkpointTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := tree.ExportCheckpoint(checkpointTime); err != nil {
+		b.Fatal(err)
+	}
+	content, err := os.ReadFile(checkpointName(tree.checkpointBaseName, checkpointTime))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		restored := InitRadix()
+		if _, err := restored.ImportPrefixes(strings.NewReader(string(content)), SourceConstruct); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkImportText(b *testing.B) {
+	benchmarkImport(b, FormatText)
+}
+
+func BenchmarkImportBinary(b *testing.B) {
+	benchmarkImport(b, FormatBinary)
+}
+
+// lookUpAddresses returns n addresses, half of them inside the alias
+// prefixes of the tree and half of them random.
+func lookUpAddresses(rng *rand.Rand, tree *Radix, n int) []netip.Addr {
+	prefixes := []netip.Prefix{}
+	tree.Walk(func(prefix netip.Prefix, info LeafInfo) bool {
+		prefixes = append(prefixes, prefix)
+		return true
+	})
+	addrs := make([]netip.Addr, 0, n)
+	for i := 0; i < n; i++ {
+		var raw [16]byte
+		rng.Read(raw[:])
+		addr := netip.AddrFrom16(raw)
+		if i%2 == 0 {
+			// Keep the host bits and take the network bits of an alias prefix.
+			prefix := prefixes[rng.Intn(len(prefixes))]
+			k := keyOf(addr)
+			base := keyOf(prefix.Addr())
+			for j := 0; j < prefix.Bits(); j++ {
+				if k.bit(j) != base.bit(j) {
+					k = k.flip(j)
+				}
+			}
+			addr = k.addr()
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+func BenchmarkLookUp(b *testing.B) {
+	rng := rand.New(rand.NewSource(7))
+	tree := randomTree(rng, 100000)
+	addrs := lookUpAddresses(rng, tree, 1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.LookUp(addrs[i%len(addrs)])
+	}
+}
+
+func BenchmarkLookUpMatch(b *testing.B) {
+	rng := rand.New(rand.NewSource(7))
+	tree := randomTree(rng, 100000)
+	addrs := lookUpAddresses(rng, tree, 1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.LookUpMatch(addrs[i%len(addrs)])
+	}
+}
+
+func TestLookUpBatch(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	tree := randomTree(rng, 2000)
+	tree.Insert(parseCIDR(t, "10.0.0.0/8"))
+	addrs := lookUpAddresses(rng, tree, 4096)
+	addrs = append(addrs, netip.MustParseAddr("10.1.2.3"), netip.MustParseAddr("10.1.2.3"), netip.MustParseAddr("11.0.0.1"))
+	sorted := append([]netip.Addr(nil), addrs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Less(sorted[j]) })
+	for _, batch := range [][]netip.Addr{addrs, sorted, {}} {
+		labels := tree.LookUpBatch(batch)
+		if len(labels) != len(batch) {
+			t.Fatalf("wrong number of labels: %d, expected %d", len(labels), len(batch))
+		}
+		for i, addr := range batch {
+			if expected := tree.LookUp(addr); !reflect.DeepEqual(labels[i], expected) {
+				t.Errorf("%s: wrong label %+v, expected %+v", addr, labels[i], expected)
+			}
+		}
+	}
+	if labels := InitRadix().LookUpBatch(sorted[:2]); labels[0].Aliased || labels[1].Aliased {
+		t.Error("nothing should be aliased in an empty tree")
+	}
+}
+
+func BenchmarkLookUpBatch(b *testing.B) {
+	rng := rand.New(rand.NewSource(7))
+	tree := randomTree(rng, 100000)
+	// Like a scan, 256 addresses are probed in each of 64 subnets.
+	addrs := []netip.Addr{}
+	for _, base := range lookUpAddresses(rng, tree, 64) {
+		k := keyOf(base)
+		for i := 0; i < 256; i++ {
+			k.lo = k.lo&^0xFFFFFFFF | uint64(rng.Uint32())
+			addrs = append(addrs, k.addr())
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Less(addrs[j]) })
+	b.Run("single", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, addr := range addrs {
+				tree.LookUp(addr)
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tree.LookUpBatch(addrs)
+		}
+	})
+}
+
+func TestLookUpMatch(t *testing.T) {
+	tree := InitRadix()
+	seen := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tree.InsertWithInfo(parseCIDR(t, "2001:db8::/32"), NewLeafInfo(SourceInsert, seen, map[string]string{"b": "2", "a": "<1>"}))
+	tree.Insert(parseCIDR(t, "192.0.2.0/24"))
+	for _, tt := range []struct {
+		ip     string
+		prefix string
+	}{
+		{"2001:db8::1", "2001:db8::/32"},
+		{"192.0.2.1", "192.0.2.0/24"},
+		{"::ffff:192.0.2.1", "192.0.2.0/24"},
+		{"2001:db9::1", ""},
+	} {
+		addr := netip.MustParseAddr(tt.ip)
+		match, found := tree.LookUpMatch(addr)
+		if found != (tt.prefix != "") || (found && match.Prefix.String() != tt.prefix) {
+			t.Errorf("%s: wrong match %v, expected %q", tt.ip, match.Prefix, tt.prefix)
+		}
+		if found {
+			expected, err := json.Marshal(match.Info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if given := match.Info.AppendJSON(nil); string(given) != string(expected) {
+				t.Errorf("%s: wrong attribute encoding:\ngiven:    %s\nexpected: %s", tt.ip, given, expected)
+			}
+		}
+		if allocs := testing.AllocsPerRun(100, func() { tree.LookUpMatch(addr) }); allocs != 0 {
+			t.Errorf("%s: %.1f allocations per lookup, expected none", tt.ip, allocs)
+		}
+	}
+}
+
+func TestInsertDefaultPrefix(t *testing.T) {
+	for _, bits := range []int{0, 8} {
+		tree := InitRadix()
+		tree.SetFrontTable(bits)
+		tree.Insert(parseCIDR(t, "2001:db8::/32"))
+		tree.Insert(parseCIDR(t, "192.0.2.0/24"))
+		if result := tree.InsertWithInfo(parseCIDR(t, "::/0"), NewLeafInfo(SourceInsert, time.Now(), nil)); result != (InsertResult{Effect: InsertNew, Prefix: parseCIDR(t, "::/0"), Pruned: 2}) {
+			t.Errorf("bits %d: wrong insert result %+v", bits, result)
+		}
+		checkStructure(t, tree.rootNode(), true)
+		if given := leaves(tree); !reflect.DeepEqual(given, []string{"::/0"}) {
+			t.Errorf("bits %d: the default prefix does not replace the tree: %v", bits, given)
+		}
+		for _, ip := range []string{"1::1", "2001:db8::1", "ffff::1", "192.0.2.1"} {
+			if label := tree.LookUp(netip.MustParseAddr(ip)); !label.Aliased || label.Metadata != "::/0" {
+				t.Errorf("bits %d: %s is not covered by the default prefix: %+v", bits, ip, label)
+			}
+		}
+		if !tree.Delete(parseCIDR(t, "::/0")) || tree.Count() != 0 || tree.LookUp(netip.MustParseAddr("1::1")).Aliased {
+			t.Errorf("bits %d: the default prefix is not deleted: %v", bits, leaves(tree))
+		}
+	}
+}
+
+func TestFrontTable(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	randomPrefix := func() netip.Prefix {
+		var raw [16]byte
+		rng.Read(raw[:])
+		return netip.PrefixFrom(netip.AddrFrom16(raw), 2+rng.Intn(30)).Masked()
+	}
+	for _, bits := range []int{1, 8, 12, 16} {
+		plain := InitRadix()
+		tree := InitRadix()
+		tree.SetFrontTable(bits)
+		inserted := []netip.Prefix{}
+		for op := 0; op < 400; op++ {
+			switch n := rng.Intn(10); {
+			case n < 6 || len(inserted) == 0:
+				prefix := randomPrefix()
+				inserted = append(inserted, prefix)
+				plain.Insert(prefix)
+				tree.Insert(prefix)
+			case n < 8:
+				prefix := inserted[rng.Intn(len(inserted))]
+				plain.Delete(prefix)
+				tree.Delete(prefix)
+			default:
+				prefix := randomPrefix()
+				prefix = netip.PrefixFrom(prefix.Addr(), prefix.Bits()/2).Masked()
+				plain.DeleteSubtree(prefix)
+				tree.DeleteSubtree(prefix)
+			}
+			// The incrementally updated table has to match a table built
+			// from scratch for the same version of the tree.
+			table := tree.front.Load()
+			fresh := newFrontTable(bits, table.root)
+			for index := uint64(0); index < 1<<bits; index++ {
+				k := key{hi: index << (64 - bits)}
+				if table.entry(k) != fresh.entry(k) {
+					t.Fatalf("bits %d, operation %d: wrong entry for index %d: %v, expected %v", bits, op, index, table.entry(k), fresh.entry(k))
+				}
+			}
+		}
+		if !reflect.DeepEqual(leaves(tree), leaves(plain)) {
+			t.Fatalf("bits %d: different alias prefixes", bits)
+		}
+		for _, addr := range lookUpAddresses(rng, plain, 2048) {
+			// Insert sets the current time, so only the prefixes are compared.
+			if given, expected := tree.LookUp(addr), plain.LookUp(addr); given.Metadata != expected.Metadata {
+				t.Errorf("bits %d: %s: wrong alias prefix %q, expected %q", bits, addr, given.Metadata, expected.Metadata)
+			}
+		}
+		if given, expected := tree.LookUpPrefix(parseCIDR(t, "8000::/1")), plain.LookUpPrefix(parseCIDR(t, "8000::/1")); !reflect.DeepEqual(given.Prefixes, expected.Prefixes) {
+			t.Errorf("bits %d: wrong coverage on the snapshot %+v, expected %+v", bits, given, expected)
+		}
+		tree.DeleteSubtree(parseCIDR(t, "::/0"))
+		if tree.LookUp(netip.MustParseAddr("8000::1")).Aliased || tree.front.Load().entry(key{}) != nil {
+			t.Errorf("bits %d: the table of an empty tree should be empty", bits)
+		}
+	}
+}
+
+// withFrontTable returns a tree with a front table sharing the nodes of tree.
+func withFrontTable(tree *Radix, bits int) *Radix {
+	fronted := InitRadix()
+	fronted.root.Store(tree.rootNode())
+	fronted.SetFrontTable(bits)
+	return fronted
+}
+
+// readTestIPs returns the addresses of a test file of the stats package.
+func readTestIPs(b *testing.B, name string) []netip.Addr {
+	content, err := os.ReadFile(filepath.Join("..", "stats", name))
+	if err != nil {
+		b.Fatal(err)
+	}
+	addrs := []netip.Addr{}
+	for _, line := range strings.Fields(string(content)) {
+		addrs = append(addrs, netip.MustParseAddr(line))
+	}
+	return addrs
+}
+
+var syntheticTree struct {
+	sync.Once
+	tree *Radix
+}
+
+func BenchmarkFrontTable(b *testing.B) {
+	type dataset struct {
+		name  string
+		tree  *Radix
+		addrs []netip.Addr
+	}
+	datasets := []func() dataset{}
+	for _, name := range []string{"testIPs5.txt", "testIPs10.txt"} {
+		name := name
+		datasets = append(datasets, func() dataset {
+			// The test addresses are alias prefixes and are looked up.
+			addrs := readTestIPs(b, name)
+			tree := InitRadix()
+			for _, addr := range addrs {
+				tree.Insert(netip.PrefixFrom(addr, 128))
+			}
+			return dataset{name, tree, addrs}
+		})
+	}
+	datasets = append(datasets, func() dataset {
+		syntheticTree.Do(func() {
+			syntheticTree.tree = randomTree(rand.New(rand.NewSource(11)), 2000000)
+		})
+		return dataset{"synthetic-2M", syntheticTree.tree, lookUpAddresses(rand.New(rand.NewSource(7)), syntheticTree.tree, 4096)}
+	})
+	for _, build := range datasets {
+		set := build()
+		for _, bits := range []int{0, 16, 24} {
+			tree := set.tree
+			if bits > 0 {
+				tree = withFrontTable(tree, bits)
+			}
+			b.Run(fmt.Sprintf("%s/front-%d", set.name, bits), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					tree.LookUpMatch(set.addrs[i%len(set.addrs)])
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkMemoryPerMillionPrefixes(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		tree := randomTree(rand.New(rand.NewSource(int64(i))), 1000000)
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/(1<<20), "MiB")
+		b.ReportMetric(float64(tree.Count()), "prefixes")
+		runtime.KeepAlive(tree)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	oldTree := InitRadix()
+	for _, prefix := range []string{"2001:db8::/48", "2001:db8:10::/48", "2001:db8:12::/48", "2001:db8:20::/44", "3001::/16", "5001::/16"} {
+		oldTree.Insert(parseCIDR(t, prefix))
+	}
+	newTree := InitRadix()
+	for _, prefix := range []string{"2001:db8::/48", "2001:db8:10::/44", "2001:db8:20::/48", "2001:db8:21::/48", "4001::/16", "5001::/17"} {
+		newTree.Insert(parseCIDR(t, prefix))
+	}
+	changes := []Change{}
+	summary := Diff(oldTree, newTree, func(change Change) {
+		changes = append(changes, change)
+	})
+	expected := []Change{
+		{Type: ChangeCoveredByNewParent, Prefix: "2001:db8:10::/48", Related: []string{"2001:db8:10::/44"}},
+		{Type: ChangeCoveredByNewParent, Prefix: "2001:db8:12::/48", Related: []string{"2001:db8:10::/44"}},
+		{Type: ChangeSplit, Prefix: "2001:db8:20::/44", Related: []string{"2001:db8:20::/47"}},
+		{Type: ChangeRemoved, Prefix: "3001::/16"},
+		{Type: ChangeSplit, Prefix: "5001::/16", Related: []string{"5001::/17"}},
+		{Type: ChangeNewlyCovering, Prefix: "2001:db8:10::/44", Related: []string{"2001:db8:10::/48", "2001:db8:12::/48"}},
+		{Type: ChangeAdded, Prefix: "4001::/16"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("wrong changes:\ngiven: %+v\nexpected: %+v", changes, expected)
+	}
+	expectedCounts := map[string]int{ChangeAdded: 1, ChangeRemoved: 1, ChangeCoveredByNewParent: 2, ChangeNewlyCovering: 1, ChangeSplit: 2}
+	if !reflect.DeepEqual(summary.Changes, expectedCounts) {
+		t.Errorf("wrong change counts: %v", summary.Changes)
+	}
+	deltas := map[int]string{}
+	for ones, delta := range summary.AddressDelta {
+		deltas[ones] = delta.String()
+	}
+	expectedDeltas := map[int]string{
+		// 3001::/16 replaced with 4001::/16, and 5001::/16 split
+		16: new(big.Int).Lsh(big.NewInt(-1), 112).String(),
+		17: new(big.Int).Lsh(big.NewInt(1), 111).String(),
+		// 2001:db8:20::/44 replaced with 2001:db8:10::/44
+		47: new(big.Int).Lsh(big.NewInt(1), 81).String(),
+		48: new(big.Int).Lsh(big.NewInt(-2), 80).String(),
+	}
+	if !reflect.DeepEqual(deltas, expectedDeltas) {
+		t.Errorf("wrong address deltas:\ngiven: %v\nexpected: %v", deltas, expectedDeltas)
+	}
+}
+
+func TestNoAggregationPolicy(t *testing.T) {
+	// Sibling prefixes of a checkpoint written without aggregation are not
+	// merged on import. The results of the set operations on the imported
+	// trees are still canonical.
+	load := func(prefixes string) *Radix {
+		tree := InitRadix()
+		tree.SetAggregationPolicy(&NoAggregationPolicy{})
+		if _, err := tree.ImportPrefixes(strings.NewReader(prefixes), SourceConstruct); err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+	a := load("2001:db8::/48\n2001:db8:1::/48\n")
+	b := load("2001:db8:2::/48\n2001:db8:3::/48\n")
+	if given := leaves(a); !reflect.DeepEqual(given, []string{"2001:db8:1::/48", "2001:db8::/48"}) {
+		t.Errorf("siblings merged on import: %v", given)
+	}
+	if given := leaves(Union(a, b)); !reflect.DeepEqual(given, []string{"2001:db8::/46"}) {
+		t.Errorf("siblings not merged by the union: %v", given)
+	}
+	if summary := Diff(a, a, func(change Change) {
+		t.Errorf("unexpected change: %+v", change)
+	}); len(summary.Changes) != 0 {
+		t.Errorf("wrong change counts: %v", summary.Changes)
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	a := InitRadix()
+	for _, prefix := range []string{"2001:db8::/48", "2001:db8:10::/44", "3001::/16"} {
+		a.Insert(parseCIDR(t, prefix))
+	}
+	b := InitRadix()
+	for _, prefix := range []string{"2001:db8:1::/48", "2001:db8:10::/48", "2001:db8:20::/44", "4001::/16"} {
+		b.Insert(parseCIDR(t, prefix))
+	}
+	for _, tt := range []struct {
+		name      string
+		operation func(a, b *Radix) *Radix
+		expected  []string
+	}{
+		{"union", Union, []string{"2001:db8:10::/44", "2001:db8:20::/44", "2001:db8::/47", "3001::/16", "4001::/16"}},
+		{"intersect", Intersect, []string{"2001:db8:10::/48"}},
+		{"difference", Difference, []string{"2001:db8:11::/48", "2001:db8:12::/47", "2001:db8:14::/46", "2001:db8:18::/45", "2001:db8::/48", "3001::/16"}},
+	} {
+		result := tt.operation(a, b)
+		checkStructure(t, result.rootNode(), true)
+		if given := leaves(result); !reflect.DeepEqual(given, tt.expected) {
+			t.Errorf("%s: wrong prefixes:\ngiven: %v\nexpected: %v", tt.name, given, tt.expected)
+		}
+	}
+	// the operands are not modified
+	if given := leaves(a); !reflect.DeepEqual(given, []string{"2001:db8:10::/44", "2001:db8::/48", "3001::/16"}) {
+		t.Errorf("first operand modified: %v", given)
+	}
+	if given := leaves(Intersect(a, InitRadix())); len(given) != 0 {
+		t.Errorf("intersection with an empty tree is not empty: %v", given)
+	}
+}
+
+func TestLookUpPrefix(t *testing.T) {
+	tree := InitRadix()
+	for _, prefix := range []string{"2001:db8::/48", "2001:db8:1:8000::/49"} {
+		tree.Insert(parseCIDR(t, prefix))
+	}
+	for _, tt := range []struct {
+		prefix   string
+		coverage string
+		prefixes []string
+		fraction float64
+	}{
+		{"2001:db8::/64", CoverageFull, []string{"2001:db8::/48"}, 1},
+		{"2001:db8::/48", CoverageFull, []string{"2001:db8::/48"}, 1},
+		{"2001:db8::/46", CoveragePartial, []string{"2001:db8::/48", "2001:db8:1:8000::/49"}, 0.375},
+		{"2001:db8:1::/48", CoveragePartial, []string{"2001:db8:1:8000::/49"}, 0.5},
+		{"2001:db8:2::/48", CoverageNone, nil, 0},
+		{"::/0", CoveragePartial, []string{"2001:db8::/48", "2001:db8:1:8000::/49"}, 0x3p-49},
+	} {
+		coverage := tree.LookUpPrefix(parseCIDR(t, tt.prefix))
+		if coverage.Coverage != tt.coverage || !reflect.DeepEqual(coverage.Prefixes, tt.prefixes) || coverage.Fraction != tt.fraction {
+			t.Errorf("%s: wrong coverage: %+v", tt.prefix, coverage)
+		}
+		if (coverage.Info != nil) != (tt.coverage == CoverageFull) {
+			t.Errorf("%s: info of the covering prefix expected only on full coverage", tt.prefix)
+		}
+	}
+	if coverage := InitRadix().LookUpPrefix(parseCIDR(t, "::/0")); coverage.Coverage != CoverageNone {
+		t.Errorf("empty tree covers ::/0: %+v", coverage)
+	}
+}
+
+func TestCoveringAndCoveredBy(t *testing.T) {
+	tree := InitRadix()
+	for _, prefix := range []string{"2001:db8::/48", "2001:db8:1:8000::/49", "2001:db8:2::/48"} {
+		tree.Insert(parseCIDR(t, prefix))
+	}
+	metadata := func(labels []Label) []string {
+		prefixes := []string{}
+		for _, label := range labels {
+			if !label.Aliased || label.Info == nil {
+				t.Errorf("label of %s is not aliased or has no attributes", label.Metadata)
+			}
+			prefixes = append(prefixes, label.Metadata)
+		}
+		return prefixes
+	}
+	for _, tt := range []struct {
+		prefix    string
+		covering  []string
+		coveredBy []string
+	}{
+		{"2001:db8::/64", []string{"2001:db8::/48"}, []string{}},
+		{"2001:db8::/48", []string{"2001:db8::/48"}, []string{"2001:db8::/48"}},
+		{"2001:db8::/46", []string{}, []string{"2001:db8::/48", "2001:db8:1:8000::/49", "2001:db8:2::/48"}},
+		{"2001:db8:1::/48", []string{}, []string{"2001:db8:1:8000::/49"}},
+		{"2001:db8:3::/48", []string{}, []string{}},
+	} {
+		prefix := parseCIDR(t, tt.prefix)
+		if given := metadata(tree.Covering(prefix)); !reflect.DeepEqual(given, tt.covering) {
+			t.Errorf("%s: wrong covering prefixes: %v, expected %v", tt.prefix, given, tt.covering)
+		}
+		if given := metadata(tree.CoveredBy(prefix)); !reflect.DeepEqual(given, tt.coveredBy) {
+			t.Errorf("%s: wrong covered prefixes: %v, expected %v", tt.prefix, given, tt.coveredBy)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	tree := InitRadix()
+	prefixes := []string{"2001:db8::/48", "2001:db8:1:8000::/49", "2001:db8:2::/48", "3001::/16"}
+	for _, prefix := range prefixes {
+		tree.InsertWithInfo(parseCIDR(t, prefix), NewLeafInfo(SourceInsert, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), map[string]string{"prefix": prefix}))
+	}
+	walk := func(within string, limit int) []string {
+		visited := []string{}
+		fn := func(prefix netip.Prefix, info LeafInfo) bool {
+			if info.Tags["prefix"] != prefix.String() {
+				t.Errorf("wrong attributes for %s: %+v", prefix, info)
+			}
+			visited = append(visited, prefix.String())
+			return len(visited) != limit
+		}
+		if within == "" {
+			tree.Walk(fn)
+		} else {
+			tree.WalkPrefix(netip.MustParsePrefix(within), fn)
+		}
+		return visited
+	}
+	for _, tt := range []struct {
+		within   string
+		limit    int
+		expected []string
+	}{
+		{"", 0, prefixes},
+		{"", 2, prefixes[:2]},
+		{"::/0", 0, prefixes},
+		{"2001:db8::/46", 0, prefixes[:3]},
+		{"2001:db8::/46", 1, prefixes[:1]},
+		{"2001:db8:1::/48", 0, prefixes[1:2]},
+		{"2001:db8::/48", 0, prefixes[:1]},
+		{"2001:db8::/64", 0, []string{}},
+		{"4001::/16", 0, []string{}},
+	} {
+		if given := walk(tt.within, tt.limit); !reflect.DeepEqual(given, tt.expected) {
+			t.Errorf("walk %q limit %d: given %v, expected %v", tt.within, tt.limit, given, tt.expected)
+		}
+	}
+	InitRadix().Walk(func(prefix netip.Prefix, info LeafInfo) bool {
+		t.Errorf("empty tree visited %s", prefix)
+		return true
+	})
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	tree := InitRadix()
+	tree.SetCheckpointBaseName(filepath.Join(t.TempDir(), "checkpoint"))
+	tree.SetCheckpointRetention(2, 0)
+	// 2001:db8::/48 stays aliased while /64s of 2001:db8:1::/48 are
+	// inserted and deleted.
+	tree.Insert(parseCIDR(t, "2001:db8::/48"))
+	before := tree.Snapshot()
+	subnet := func(i int) netip.Prefix {
+		// Not adjacent, so they are never merged.
+		return parseCIDR(t, fmt.Sprintf("2001:db8:1:%x::/64", 4*i))
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 512; i += 4 {
+				tree.Insert(subnet(i))
+				if i%3 == 0 {
+					tree.Delete(subnet(i))
+				}
+			}
+		}(w)
+	}
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			ip := netip.MustParseAddr("2001:db8::1")
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if !tree.LookUp(ip).Aliased {
+					t.Error("2001:db8::1 is not aliased during modifications")
+					return
+				}
+				tree.LookUpPrefix(parseCIDR(t, "2001:db8:1::/48"))
+				previous := netip.Prefix{}
+				tree.Walk(func(prefix netip.Prefix, info LeafInfo) bool {
+					if previous.IsValid() && previous.Addr().Compare(prefix.Addr()) >= 0 {
+						t.Errorf("walk is not in address order: %s before %s", previous, prefix)
+					}
+					previous = prefix
+					return true
+				})
+			}
+		}()
+	}
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		checkpointTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			checkpointTime = checkpointTime.Add(time.Second)
+			if err := tree.ExportCheckpoint(checkpointTime); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(done)
+	readers.Wait()
+
+	checkStructure(t, tree.rootNode(), true)
+	for i := 0; i < 512; i++ {
+		if aliased := tree.IsAliased(subnet(i)); aliased != (i%3 != 0) {
+			t.Errorf("%s aliased: %t", subnet(i), aliased)
+		}
+	}
+	if given := leaves(before); !reflect.DeepEqual(given, []string{"2001:db8::/48"}) {
+		t.Errorf("snapshot is modified: %v", given)
+	}
+	checkpointFile, _, err := FindLatestCheckpoint(tree.checkpointBaseName)
+	if err != nil || checkpointFile == "" {
+		t.Fatalf("no checkpoint exported: %v", err)
+	}
+	restored := InitRadix()
+	if _, err := restored.ImportPrefixFile(checkpointFile, SourceConstruct); err != nil {
+		t.Fatal(err)
+	}
+	checkStructure(t, restored.rootNode(), true)
+}
+
+func TestIPv4(t *testing.T) {
+	tree := InitRadix()
+	for _, prefix := range []string{"2001:db8::/48", "10.0.0.0/8", "192.0.2.0/25", "192.0.2.128/25", "::ffff:198.51.100.0/120"} {
+		tree.Insert(parseCIDR(t, prefix))
+	}
+	for _, tt := range []struct {
+		ip       string
+		metadata string
+	}{
+		{"10.1.2.3", "10.0.0.0/8"},
+		{"::ffff:10.1.2.3", "10.0.0.0/8"},
+		{"192.0.2.200", "192.0.2.0/24"},
+		{"198.51.100.7", "198.51.100.0/24"},
+		{"11.0.0.1", ""},
+		// IPv4-compatible addresses are IPv6 addresses
+		{"::10.1.2.3", ""},
+		{"2001:db8::1", "2001:db8::/48"},
+	} {
+		label := tree.LookUp(netip.MustParseAddr(tt.ip))
+		if label.Aliased != (tt.metadata != "") || label.Metadata != tt.metadata {
+			t.Errorf("%s: wrong label %+v, expected %q", tt.ip, label, tt.metadata)
+		}
+	}
+	if !tree.IsAliased(parseCIDR(t, "10.20.0.0/16")) || tree.IsAliased(parseCIDR(t, "10.0.0.0/7")) {
+		t.Error("wrong IPv4 prefix alias check")
+	}
+	expected := []string{"10.0.0.0/8", "192.0.2.0/24", "198.51.100.0/24"}
+	walked := []string{}
+	tree.WalkPrefix(netip.MustParsePrefix("0.0.0.0/0"), func(prefix netip.Prefix, info LeafInfo) bool {
+		walked = append(walked, prefix.String())
+		return true
+	})
+	if !reflect.DeepEqual(walked, expected) {
+		t.Errorf("wrong IPv4 prefixes: %v, expected %v", walked, expected)
+	}
+	if coverage := tree.LookUpPrefix(parseCIDR(t, "192.0.0.0/16")); !reflect.DeepEqual(coverage.Prefixes, []string{"192.0.2.0/24"}) || coverage.Fraction != 1.0/256 {
+		t.Errorf("wrong IPv4 coverage: %+v", coverage)
+	}
+	checkpoint := readCheckpoint(t, tree, t.TempDir(), "checkpoint")
+	prefixes := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(checkpoint), "\n") {
+		prefix, _, _ := strings.Cut(line, "\t")
+		prefixes = append(prefixes, prefix)
+	}
+	if !reflect.DeepEqual(prefixes, append(expected, "2001:db8::/48")) {
+		t.Errorf("wrong checkpoint prefixes: %v", prefixes)
+	}
+	if !tree.Delete(parseCIDR(t, "10.0.0.0/8")) || tree.LookUp(netip.MustParseAddr("10.1.2.3")).Aliased {
+		t.Error("cannot delete IPv4 prefix")
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package radix
+
+import (
+	"net/netip"
+)
+
+// copyInfo returns a copy of the attributes which can be updated without
+// affecting the original tree.
+func copyInfo(info *LeafInfo) *LeafInfo {
+	if info == nil {
+		return nil
+	}
+	dup := *info
+	return &dup
+}
+
+// leavesUnder returns the alias leaves inside prefix, or in the whole tree if
+// prefix is the zero Prefix, in address order.
+func (t *Radix) leavesUnder(prefix netip.Prefix) []*Node {
+	nodes := []*Node{}
+	t.walkLeaves(prefix, func(leaf *Node) bool {
+		nodes = append(nodes, leaf)
+		return true
+	})
+	return nodes
+}
+
+// insertLeafCopy inserts the prefix of the given leaf with a copy of its
+// attributes into the tree.
+func (t *Radix) insertLeafCopy(leaf *Node) {
+	t.insert(leaf.prefix(), copyInfo(leaf.info))
+}
+
+// subtract inserts the parts of prefix which are not covered by any of the
+// given leaves, which are all inside prefix, into the tree. prefix is split
+// into its halves until each half is either fully outside or fully inside the
+// leaves.
+func (t *Radix) subtract(prefix netip.Prefix, info *LeafInfo, leaves []*Node) {
+	ones := prefix.Bits()
+	if len(leaves) == 0 {
+		t.insert(prefix, copyInfo(info))
+		return
+	}
+	if int(leaves[0].endPrefix) == ones {
+		// prefix itself is removed.
+		return
+	}
+	low := netip.PrefixFrom(prefix.Addr(), ones+1)
+	high := netip.PrefixFrom(keyOf(prefix.Addr()).flip(ones).addr(), ones+1)
+	split := 0
+	for split < len(leaves) && leaves[split].value.bit(ones) == 0 {
+		split++
+	}
+	t.subtract(low, info, leaves[:split])
+	t.subtract(high, info, leaves[split:])
+}
+
+// The results of the set operations are built with the default aggregation
+// policy, which merges sibling prefixes, so they are in canonical minimal form.
+
+// Union returns a new tree with the alias prefixes covered by either of the
+// trees. Attributes of identical alias prefixes are merged.
+func Union(a, b *Radix) *Radix {
+	result := InitRadix()
+	for _, leaf := range a.allLeaves() {
+		result.insertLeafCopy(leaf)
+	}
+	for _, leaf := range b.allLeaves() {
+		result.insertLeafCopy(leaf)
+	}
+	return result
+}
+
+// Intersect returns a new tree with the alias prefixes covered by both of
+// the trees. The attributes are taken from the longer alias prefix.
+func Intersect(a, b *Radix) *Radix {
+	result := InitRadix()
+	for _, leaf := range a.allLeaves() {
+		if covering := b.lookupNode(leaf.value); covering != nil && covering.endPrefix <= leaf.endPrefix {
+			result.insertLeafCopy(leaf)
+			continue
+		}
+		for _, covered := range b.leavesUnder(leaf.prefix()) {
+			result.insertLeafCopy(covered)
+		}
+	}
+	return result
+}
+
+// Difference returns a new tree with the alias prefixes covered by a but not
+// by b. Alias prefixes of a which are partially covered by b are split into
+// the remaining parts, which keep the attributes of the original prefix.
+func Difference(a, b *Radix) *Radix {
+	result := InitRadix()
+	for _, leaf := range a.allLeaves() {
+		if covering := b.lookupNode(leaf.value); covering != nil && covering.endPrefix <= leaf.endPrefix {
+			continue
+		}
+		result.subtract(leaf.prefix(), leaf.info, b.leavesUnder(leaf.prefix()))
+	}
+	return result
+}
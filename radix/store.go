@@ -0,0 +1,98 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package radix
+
+import (
+	"io"
+	"net/netip"
+	"time"
+)
+
+// InsertEffect is how an insertion changed the alias prefixes.
+type InsertEffect string
+
+const (
+	InsertNew       = InsertEffect("new")       // The prefix is a new alias prefix
+	InsertConfirmed = InsertEffect("confirmed") // The prefix was already an alias prefix, its attributes are updated
+	InsertCovered   = InsertEffect("covered")   // The prefix is inside a shorter alias prefix and is not added
+	InsertMerged    = InsertEffect("merged")    // The prefix is added and merged into a parent inferred by the aggregation policy
+)
+
+// InsertResult describes the effect of an insertion.
+type InsertResult struct {
+	Effect InsertEffect `json:"effect"`
+	// Prefix is the alias prefix containing the inserted prefix afterwards:
+	// the prefix itself, the alias prefix covering it, or the largest
+	// inferred parent.
+	Prefix netip.Prefix `json:"prefix"`
+	// Pruned is the number of alias prefixes inside the inserted prefix
+	// which it replaced.
+	Pruned int `json:"pruned,omitempty"`
+}
+
+// Store holds non-overlapping alias prefixes and looks up the alias prefix
+// containing an address. The dealiasing commands run on a Store, which is
+// either a Radix or an amt.PrefixTrie (--backend). Both are safe for concurrent
+// lookups during a modification.
+type Store interface {
+	// InsertWithInfo adds prefix as an alias prefix with the given
+	// attributes. Alias prefixes under it are removed, and it is not added
+	// if it is inside an alias prefix.
+	InsertWithInfo(prefix netip.Prefix, info *LeafInfo) InsertResult
+	// Delete removes the exact alias prefix and reports whether it was present.
+	Delete(prefix netip.Prefix) bool
+	// DeleteSubtree removes every alias prefix inside prefix and returns
+	// their number.
+	DeleteSubtree(prefix netip.Prefix) int
+	// IsAliased reports whether the whole prefix is inside an alias prefix.
+	IsAliased(prefix netip.Prefix) bool
+	// LookUp returns the label of the alias prefix containing addr.
+	LookUp(addr netip.Addr) Label
+	// LookUpMatch returns the alias prefix containing addr without allocating.
+	LookUpMatch(addr netip.Addr) (Match, bool)
+	// LookUpBatch returns the labels of each of addrs, in the same order.
+	LookUpBatch(addrs []netip.Addr) []Label
+	// LookUpPrefix reports how much of prefix is covered by alias prefixes.
+	LookUpPrefix(prefix netip.Prefix) Coverage
+	// Covering returns the alias prefix containing prefix, if any.
+	Covering(prefix netip.Prefix) []Label
+	// CoveredBy returns the alias prefixes inside prefix in address order.
+	CoveredBy(prefix netip.Prefix) []Label
+	// Walk calls fn for each alias prefix in address order until fn
+	// returns false.
+	Walk(fn func(prefix netip.Prefix, info LeafInfo) bool)
+	// Count returns the number of alias prefixes.
+	Count() int
+	// Export writes the alias prefixes to w in the given checkpoint format.
+	Export(w io.Writer, format string, createdAt time.Time) error
+	// ImportPrefixFile inserts the alias prefixes of a construct input file
+	// or a checkpoint.
+	ImportPrefixFile(filename, source string) (int, error)
+	// View returns the alias prefixes as of now. Later modifications of the
+	// store do not affect the view.
+	View() Store
+	// ExportCheckpoint writes the alias prefixes to a new checkpoint.
+	ExportCheckpoint(checkpointTime time.Time) error
+	SetAggregationPolicy(policy AggregationPolicy)
+	SetCheckpointBaseName(checkpointBaseName string)
+	SetCheckpointFrequency(checkpointFrequency float32)
+	SetCheckpointRetention(keep int, maxAge time.Duration)
+	SetCheckpointFormat(format string)
+	IsChanged() bool
+	SetChange(val bool)
+	GetCheckpointFrequency() float32
+	CheckConstructionNewAliasFound() bool
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package radix
+
+import (
+	"net/netip"
+)
+
+// subtreeUnder returns the highest node under root inside prefix, which is a
+// normalized prefix, or nil if there is no alias prefix inside prefix.
+func subtreeUnder(root *Node, prefix netip.Prefix) *Node {
+	if root.isLeaf {
+		return nil
+	}
+	ones := prefix.Bits()
+	if ones == 0 {
+		return root
+	}
+	ipKey := keyOf(prefix.Addr())
+	current := root
+	for i := 0; ; {
+		var child *Node
+		for j := 0; j < len(current.children); j++ {
+			if current.children[j].matchesBit(ipKey, i) {
+				child = current.children[j]
+				break
+			}
+		}
+		if child == nil {
+			return nil
+		}
+		endPrefix := int(child.endPrefix)
+		if endPrefix > ones {
+			endPrefix = ones
+		}
+		if child.value.commonPrefixLen(ipKey) < endPrefix {
+			return nil
+		}
+		if int(child.endPrefix) >= ones {
+			return child
+		}
+		if child.isLeaf {
+			return nil
+		}
+		current = child
+		i = int(child.endPrefix)
+	}
+}
+
+// walk calls fn for each alias leaf in the sub-tree of n in address order
+// until fn returns false. It reports whether every leaf was visited.
+func (n *Node) walk(fn func(leaf *Node) bool) bool {
+	if n.isLeaf {
+		return fn(n)
+	}
+	for i := 0; i < len(n.children); i++ {
+		if !n.children[i].walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// walkLeaves calls fn for each alias leaf inside within, or in the whole
+// tree if within is the zero Prefix, in address order until fn returns false.
+func (t *Radix) walkLeaves(within netip.Prefix, fn func(leaf *Node) bool) {
+	n := t.rootNode()
+	if n.isLeaf {
+		return
+	}
+	if within.IsValid() {
+		if n = subtreeUnder(n, within); n == nil {
+			return
+		}
+	}
+	n.walk(fn)
+}
+
+// walkFunc calls fn with the prefix and a copy of the attributes of a leaf.
+func walkFunc(fn func(prefix netip.Prefix, info LeafInfo) bool) func(leaf *Node) bool {
+	return func(leaf *Node) bool {
+		var info LeafInfo
+		if leaf.info != nil {
+			info = *leaf.info
+		}
+		return fn(leaf.familyPrefix(), info)
+	}
+}
+
+// Walk calls fn for each alias prefix in the tree in address order until fn
+// returns false. It walks the tree as of the call, modifications made in
+// the meantime are not visited.
+func (t *Radix) Walk(fn func(prefix netip.Prefix, info LeafInfo) bool) {
+	t.walkLeaves(netip.Prefix{}, walkFunc(fn))
+}
+
+// WalkPrefix calls fn for each alias prefix inside within, including within
+// itself if it is an alias prefix, in address order until fn returns false.
+// Like Walk, it walks the tree as of the call. IPv4 prefixes are walked
+// inside ::ffff:0:0/96.
+func (t *Radix) WalkPrefix(within netip.Prefix, fn func(prefix netip.Prefix, info LeafInfo) bool) {
+	if !within.IsValid() {
+		return
+	}
+	t.walkLeaves(normalizePrefix(within), walkFunc(fn))
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package aliasv6
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ParseListenAddress returns the network and the address of a listen
+// address given as tcp://host:port or unix:///path.
+func ParseListenAddress(listen string) (network, address string, err error) {
+	network, address, found := strings.Cut(listen, "://")
+	if !found || address == "" {
+		return "", "", fmt.Errorf("listen address should be tcp://host:port or unix:///path, given %s", listen)
+	}
+	switch network {
+	case "tcp", "unix":
+		return network, address, nil
+	default:
+		return "", "", fmt.Errorf("unsupported network %s in listen address %s, should be tcp or unix", network, listen)
+	}
+}
+
+// Listen returns a listener on a tcp://host:port or unix:///path address.
+// The socket file of a previous run at the unix path is removed.
+func Listen(listen string) (net.Listener, error) {
+	network, address, err := ParseListenAddress(listen)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(address); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// Server accepts sessions on listeners. Each connection is a session with
+// its own command stream, run by the shared Engine, and its responses are
+// written back on the connection. A quit command only ends its session.
+type Server struct {
+	engine    *Engine
+	mutex     sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	sessions  sync.WaitGroup
+	// count is the number of accepted sessions, which numbers them in the
+	// logs.
+	count int
+}
+
+// NewServer returns a Server running the sessions on engine.
+func NewServer(engine *Engine) *Server {
+	return &Server{
+		engine:    engine,
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+	}
+}
+
+// Serve accepts sessions on l until the server is closed, in which case it
+// returns nil.
+func (s *Server) Serve(l net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listeners[l] = struct{}{}
+	s.mutex.Unlock()
+	log.Infof("accepting sessions on %s://%s", l.Addr().Network(), l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed && errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		id, ok := s.track(conn)
+		if !ok {
+			conn.Close()
+			return nil
+		}
+		go s.runSession(id, conn)
+	}
+}
+
+// track records an accepted connection and returns the number of its
+// session. It returns false if the server is closed.
+func (s *Server) track(conn net.Conn) (int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return 0, false
+	}
+	s.conns[conn] = struct{}{}
+	s.sessions.Add(1)
+	s.count++
+	return s.count, true
+}
+
+// runSession runs the commands received on conn until the client closes
+// it or sends a quit command.
+func (s *Server) runSession(id int, conn net.Conn) {
+	defer s.sessions.Done()
+	log.Infof("session %d started from %s://%s", id, conn.RemoteAddr().Network(), conn.RemoteAddr())
+	input := func(ch chan<- Command) error {
+		return GetTargets(conn, ch)
+	}
+	if err := s.engine.RunSession(input, OutputResultsSessionFunc(conn)); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Errorf("session %d: %v", id, err)
+	}
+	s.mutex.Lock()
+	delete(s.conns, conn)
+	s.mutex.Unlock()
+	conn.Close()
+	log.Infof("session %d ended", id)
+}
+
+// Close stops accepting sessions, closes the connections of the running
+// sessions and waits for them to end.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(s.listeners, l)
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+	s.sessions.Wait()
+	return err
+}
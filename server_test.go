@@ -0,0 +1,199 @@
+/*
+Copyright 2024 Georgia Institute of Technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package aliasv6
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testServer returns a server on the tree of lookUpTree, accepting sessions
+// on the given listen address, and a function which closes it.
+func testServer(t *testing.T, listen string) (*Server, net.Addr, func()) {
+	workers := config.NumLookUpWorkers
+	config.NumLookUpWorkers = 4
+	mon, stopMonitor := testMonitor()
+	server := NewServer(NewEngine(lookUpTree(), nil, mon))
+	l, err := Listen(listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(l)
+	}()
+	return server, l.Addr(), func() {
+		if err := server.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := <-served; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+		stopMonitor()
+		config.NumLookUpWorkers = workers
+	}
+}
+
+// session is a client connection to a test server.
+type session struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, addr net.Addr) *session {
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &session{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// send writes a command to the server.
+func (s *session) send(command string) {
+	if _, err := fmt.Fprintln(s.conn, command); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// receive returns the next response of the server.
+func (s *session) receive() map[string]interface{} {
+	line, err := s.reader.ReadBytes('\n')
+	if err != nil {
+		s.t.Fatalf("cannot read a response: %v", err)
+	}
+	response := map[string]interface{}{}
+	if err := json.Unmarshal(line, &response); err != nil {
+		s.t.Fatalf("invalid response %s: %v", line, err)
+	}
+	return response
+}
+
+// request sends a command and returns its response.
+func (s *session) request(command string) map[string]interface{} {
+	s.send(command)
+	return s.receive()
+}
+
+func TestServer(t *testing.T) {
+	for _, listen := range []string{
+		"tcp://127.0.0.1:0",
+		"unix://" + filepath.Join(t.TempDir(), "aliasv6.sock"),
+	} {
+		_, addr, stop := testServer(t, listen)
+		first, second := dial(t, addr), dial(t, addr)
+
+		// The sessions share the tree.
+		if response := first.request(`{"id":1,"type":"insert","data":"2001:db9::/48"}`); response["status"] != string(LOOKUP_SUCCESS) || response["id"] != 1.0 {
+			t.Errorf("%s: wrong insert response: %v", listen, response)
+		}
+		if response := second.request(`{"id":2,"type":"lookup","data":"2001:db9::1"}`); response["status"] != string(LOOKUP_SUCCESS) || response["id"] != 2.0 {
+			t.Errorf("%s: the insert of another session is not visible: %v", listen, response)
+		}
+		if response := second.request("not an ip"); response["status"] != string(LOOKUP_PARSE_ERROR) {
+			t.Errorf("%s: wrong response to an invalid command: %v", listen, response)
+		}
+
+		// Pipelined lookups are answered on their own connection.
+		for i := 0; i < 100; i++ {
+			first.send(fmt.Sprintf(`{"id":%d,"type":"lookup","data":"2001:db8::%x"}`, i, i))
+		}
+		seen := map[float64]bool{}
+		for i := 0; i < 100; i++ {
+			response := first.receive()
+			id, _ := response["id"].(float64)
+			if response["status"] != string(LOOKUP_SUCCESS) || seen[id] {
+				t.Errorf("%s: wrong pipelined response: %v", listen, response)
+			}
+			seen[id] = true
+		}
+
+		// quit ends its session only.
+		if response := first.request(`{"id":"bye","type":"quit"}`); response["type"] != "quit" || response["id"] != "bye" {
+			t.Errorf("%s: wrong quit response: %v", listen, response)
+		}
+		if _, err := first.reader.ReadByte(); err == nil {
+			t.Errorf("%s: the session is still open after quit", listen)
+		}
+		if response := second.request(`{"type":"lookup","data":"192.0.2.1"}`); response["status"] != string(LOOKUP_SUCCESS) {
+			t.Errorf("%s: wrong lookup after another session quit: %v", listen, response)
+		}
+		third := dial(t, addr)
+		if response := third.request(`{"type":"lookup","data":"2001:db9::1"}`); response["status"] != string(LOOKUP_SUCCESS) {
+			t.Errorf("%s: wrong lookup in a new session: %v", listen, response)
+		}
+
+		// Closing the server ends the remaining sessions.
+		stop()
+		for _, s := range []*session{second, third} {
+			if _, err := s.reader.ReadByte(); err == nil {
+				t.Errorf("%s: the session is still open after the server is closed", listen)
+			}
+			s.conn.Close()
+		}
+		first.conn.Close()
+	}
+}
+
+func TestServerClientClose(t *testing.T) {
+	_, addr, stop := testServer(t, "tcp://127.0.0.1:0")
+	defer stop()
+	// A client which closes its side of the connection gets the responses
+	// to the commands it sent, including a last one without a newline.
+	client := dial(t, addr)
+	client.send(`{"type":"lookup-prefix","data":"2001:db8::/64"}`)
+	client.send(`{"type":"covering","data":"2001:db8::1"}`)
+	if _, err := client.conn.Write([]byte(`{"type":"lookup","data":"2001:db8::2"}`)); err != nil {
+		t.Fatal(err)
+	}
+	client.conn.(*net.TCPConn).CloseWrite()
+	for i := 0; i < 3; i++ {
+		if response := client.receive(); response["status"] != string(LOOKUP_SUCCESS) {
+			t.Errorf("wrong response: %v", response)
+		}
+	}
+	if _, err := client.reader.ReadByte(); err == nil {
+		t.Error("the session is still open once the input ended")
+	}
+	client.conn.Close()
+}
+
+func TestParseListenAddress(t *testing.T) {
+	for _, tt := range []struct {
+		listen  string
+		network string
+		address string
+		valid   bool
+	}{
+		{"tcp://127.0.0.1:9000", "tcp", "127.0.0.1:9000", true},
+		{"tcp://[::1]:9000", "tcp", "[::1]:9000", true},
+		{"unix:///run/aliasv6.sock", "unix", "/run/aliasv6.sock", true},
+		{"udp://127.0.0.1:9000", "", "", false},
+		{"127.0.0.1:9000", "", "", false},
+		{"tcp://", "", "", false},
+	} {
+		network, address, err := ParseListenAddress(tt.listen)
+		if (err == nil) != tt.valid || network != tt.network || address != tt.address {
+			t.Errorf("%s: given %s %s %v", tt.listen, network, address, err)
+		}
+	}
+}
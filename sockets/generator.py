@@ -18,10 +18,13 @@ from subprocess import Popen, PIPE
 import json
 import time
 
-def create_command(type, data=None):
+def create_command(type, data=None, id=None):
     if type not in ["lookup", "insert", "quit"]:
         return {}
     command = {"Type": type}
+    if id is not None:
+        # echoed as "id" on the responses, which may come back in any order
+        command["ID"] = id
     if type in ["lookup", "insert"]:
         if not data:
             return {}
@@ -29,9 +29,12 @@ type LookUpStatus string
 // TODO: Conform to standard string const format (names, capitalization, hyphens/underscores, etc)
 // TODO: Enumerate further status types
 const (
-	LOOKUP_SUCCESS       = LookUpStatus("success")       // The protocol in question was positively identified and the lookup encountered no errors
-	LOOKUP_NO_MATCH      = LookUpStatus("no-match")      // No positive match on the aliased lookup table
-	LOOKUP_UNKNOWN_ERROR = LookUpStatus("unknown-error") // Catch-all for unrecognized errors
+	LOOKUP_SUCCESS         = LookUpStatus("success")         // The protocol in question was positively identified and the lookup encountered no errors
+	LOOKUP_NO_MATCH        = LookUpStatus("no-match")        // No positive match on the aliased lookup table
+	LOOKUP_PARTIAL_MATCH   = LookUpStatus("partial-match")   // The looked up prefix is only partially covered by the aliased lookup table
+	LOOKUP_UNKNOWN_ERROR   = LookUpStatus("unknown-error")   // Catch-all for unrecognized errors
+	LOOKUP_PARSE_ERROR     = LookUpStatus("parse-error")     // The command or its target could not be parsed
+	LOOKUP_INVALID_COMMAND = LookUpStatus("invalid-command") // The command is parsed but cannot be run, e.g. an unknown type or an insert of an address
 )
 
 // LookUpError an error that also includes a LookUpStatus.
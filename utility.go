@@ -24,6 +24,8 @@ var parser *flags.Parser
 
 func init() {
 	parser = flags.NewParser(&config, flags.Default)
+	// Without a command, the program runs in the default dealiasing mode.
+	parser.SubcommandsOptional = true
 }
 
 // ParseCommandLine parses the commands given on the command line
@@ -32,6 +34,9 @@ func init() {
 func ParseCommandLine(args []string) ([]string, Config, error) {
 	posArgs, err := parser.ParseArgs(args)
 	if err == nil {
+		if parser.Active != nil {
+			config.Command = parser.Active.Name
+		}
 		validateFrameworkConfiguration()
 	}
 	return posArgs, config, err